@@ -3,14 +3,14 @@ package main
 import (
 	"errors"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
+	"syscall"
 
 	"github.com/GregoryDosh/automidically/internal/configurator"
 	"github.com/GregoryDosh/automidically/internal/singleinstance"
-	tray "github.com/GregoryDosh/automidically/internal/systray"
 	"github.com/GregoryDosh/automidically/internal/toaster"
-	"github.com/getlantern/systray"
 	"github.com/orandin/lumberjackrus"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -30,7 +30,7 @@ func main() {
 	app := &cli.App{
 		Name:     "automidically",
 		HelpName: "automidically",
-		Usage:    "hooks MIDI device inputs to Windows System Volume(s)",
+		Usage:    "hooks MIDI device inputs to system volume(s)",
 		Authors: []*cli.Author{
 			{Name: "Gregory Dosh"},
 		},
@@ -65,6 +65,12 @@ func main() {
 				Usage:   "Enables Windows 10 Notifications",
 				Value:   false,
 			},
+			&cli.BoolFlag{
+				EnvVars: []string{"HEADLESS"},
+				Name:    "headless",
+				Usage:   "Run without the system tray, stop with SIGINT/SIGTERM and reload the config with SIGHUP",
+				Value:   false,
+			},
 			&cli.StringFlag{
 				EnvVars:     []string{"PROFILE_CPU"},
 				Name:        "profile_cpu",
@@ -155,14 +161,22 @@ func automidicallyMain(ctx *cli.Context) error {
 	}).Info()
 
 	c := configurator.New(configFilename)
-	systray.Run(tray.Start(c.HandleSystrayMessage), func() {})
+	if ctx.Bool("headless") {
+		runHeadless(c)
+	} else {
+		runSystray(c)
+	}
 
-	if c.MIDIDevice != nil {
-		err := c.MIDIDevice.Cleanup()
-		if err != nil {
+	c.Lock()
+	for _, d := range c.MIDIDevices {
+		if d == nil {
+			continue
+		}
+		if err := d.Cleanup(); err != nil {
 			log.Error(err)
 		}
 	}
+	c.Unlock()
 	log.Info("exiting gracefully")
 
 	if profileMemoryFilename != "" {
@@ -180,3 +194,24 @@ func automidicallyMain(ctx *cli.Context) error {
 
 	return nil
 }
+
+// runHeadless blocks until the process is told to stop, taking the place of the systray. The signals do the same thing
+// as the menu items, so shutdown happens in the same order either way. Nothing here needs the systray, so headless
+// builds can leave it out.
+func runHeadless(c *configurator.Configurator) {
+	log.Info("running headless")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			log.Info("reloading config")
+			c.ReloadConfig()
+			continue
+		}
+		log.Infof("received %s, shutting down", sig)
+		c.Quit()
+		return
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !windows || headless
+// +build !windows headless
+
+package main
+
+import (
+	"github.com/GregoryDosh/automidically/internal/configurator"
+)
+
+// runSystray runs headless, there's only a systray on Windows and headless builds leave it out.
+func runSystray(c *configurator.Configurator) {
+	runHeadless(c)
+}
@@ -0,0 +1,15 @@
+//go:build !headless
+// +build !headless
+
+package main
+
+import (
+	"github.com/GregoryDosh/automidically/internal/configurator"
+	tray "github.com/GregoryDosh/automidically/internal/systray"
+	"github.com/getlantern/systray"
+)
+
+// runSystray shows the systray and blocks until Quit is clicked.
+func runSystray(c *configurator.Configurator) {
+	systray.Run(tray.Start(c.HandleSystrayMessage), func() {})
+}
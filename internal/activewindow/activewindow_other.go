@@ -0,0 +1,10 @@
+//go:build !windows
+// +build !windows
+
+package activewindow
+
+// startListenerMessageLoop doesn't have anything to listen to outside of Windows. There isn't one way to find the
+// focused window that works across X11 & the Wayland compositors, so the active window always stays empty.
+func startListenerMessageLoop() {
+	log.Debug("active window detection isn't supported on this platform")
+}
@@ -0,0 +1,78 @@
+package activewindow
+
+import (
+	"strings"
+
+	"github.com/lxn/win"
+	"github.com/mitchellh/go-ps"
+	"github.com/sirupsen/logrus"
+)
+
+var activeWindowHandler win.HWINEVENTHOOK
+
+// newActiveWindowCallback is passed to Windows to be called whenever the active window changes.
+// When it is called it will attempt to find the process of an associated handle, then get the executable associated with that.
+func (l *Listener) newActiveWindowCallback(hWinEventHook win.HWINEVENTHOOK, event uint32, hwnd win.HWND, idObject int32, idChild int32, idEventThread uint32, dwmsEventTime uint32) (ret uintptr) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if hwnd == 0 {
+		return
+	}
+
+	var pid uint32 = 0
+	win.GetWindowThreadProcessId(hwnd, &pid)
+	if pid == 0 {
+		log.Debugf("unable to find PID from HWND %d", hwnd)
+		return
+	}
+	l.processID = int(pid)
+
+	p, err := ps.FindProcess(l.processID)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	l.processFilename = strings.ToLower(p.Executable())
+
+	log.WithFields(logrus.Fields{
+		"filename": l.processFilename,
+		"pid":      l.processID,
+	}).Trace("new active window")
+
+	return 0
+}
+
+func startListenerMessageLoop() {
+	log.Trace("Starting Message Listener Loop")
+	// The windows event hook will allow us to know when the active window has changed.
+	handle, err := setActiveWindowWinEventHook(listener.newActiveWindowCallback)
+	if err != nil {
+		log.Fatal(err)
+	}
+	activeWindowHandler = handle
+
+	msg := win.MSG{}
+	for win.GetMessage(&msg, 0, 0, 0) != 0 {
+		win.TranslateMessage(&msg)
+		win.DispatchMessage(&msg)
+	}
+}
+
+// setActiveWindowWinEventHook is for informing windows which function should be called whenever a
+// foreground window has changed. https://docs.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-setwineventhook
+func setActiveWindowWinEventHook(callbackFunction win.WINEVENTPROC) (win.HWINEVENTHOOK, error) {
+	ret, err := win.SetWinEventHook(
+		3,
+		3,
+		0,
+		callbackFunction,
+		0,
+		0,
+		win.WINEVENT_OUTOFCONTEXT|win.WINEVENT_SKIPOWNPROCESS,
+	)
+	if ret == 0 {
+		return 0, err
+	}
+
+	return ret, nil
+}
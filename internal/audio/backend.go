@@ -0,0 +1,157 @@
+package audio
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	log                  = logrus.WithField("module", "audio")
+	ErrorSessionExpired  = errors.New("audio session expired")
+	ErrorSessionNotFound = errors.New("audio session not found")
+	ErrorDeviceNotFound  = errors.New("audio device not found")
+)
+
+// Flow is the direction audio goes through a device, out of the speakers or in from a microphone.
+type Flow int
+
+const (
+	Output Flow = iota
+	Input
+)
+
+func (f Flow) String() string {
+	switch f {
+	case Output:
+		return "output"
+	case Input:
+		return "input"
+	}
+	return "unknown"
+}
+
+// Role is the kind of default device. Windows keeps a default for each role, most other systems only have console.
+type Role int
+
+const (
+	Console Role = iota
+	Multimedia
+	Communications
+)
+
+func (r Role) String() string {
+	switch r {
+	case Console:
+		return "console"
+	case Multimedia:
+		return "multimedia"
+	case Communications:
+		return "communications"
+	}
+	return "unknown"
+}
+
+// Control is the common ground between devices and audio sessions so mappings can treat them the same.
+// Volumes are floats on the scale of 0-1.
+type Control interface {
+	GetVolumeLevel() (float32, error)
+	SetVolumeLevel(float32) error
+	GetMute() (bool, error)
+	SetMute(bool) error
+	// LiveState is the volume & mute as of the last change the backend heard about, ok is false if it doesn't know.
+	LiveState() (volume float32, mute bool, ok bool)
+}
+
+// Device is an output or input device like speakers, headphones, or a microphone.
+// The ID, Name, and Flow of a device don't change, so they're fine to use after a View is over.
+type Device interface {
+	Control
+	ID() string
+	Name() string
+	Flow() Flow
+	// IsDefault reports if the device is the default of its flow for the role.
+	IsDefault(role Role) bool
+	// Sessions returns the audio sessions playing on, or recording from, the device.
+	Sessions() []Session
+}
+
+// Process is what's known about a process with an audio session, or one of its ancestors.
+type Process interface {
+	Pid() int
+	// Executable is the filename of the process, e.g. game.exe.
+	Executable() string
+	// Path is the full path of the executable, empty if it can't be found.
+	Path() string
+	// WindowTitles are the titles of the visible windows of the process, if the platform has any.
+	WindowTitles() []string
+}
+
+// Session is the audio of one application on a device, with its own volume & mute.
+type Session interface {
+	Control
+	Process
+	// DisplayName is set by some applications to describe the session, it's often empty.
+	DisplayName() string
+	// IsSystem is true for the system sounds, the dings & alerts.
+	IsSystem() bool
+	// Ancestors are the parent, grandparent, etc. of the process, nearest first.
+	Ancestors() []Process
+}
+
+// Backend is the audio system of the platform, e.g. WASAPI on Windows. The mapping logic lives in the Router
+// so a backend only has to keep track of the devices & sessions and pass changes along.
+type Backend interface {
+	// View calls fn with all of the devices, they won't be cleaned up until fn returns.
+	View(fn func(devices []Device))
+	// SetDefaultDevice makes the device with the id the default of its flow for each of the roles.
+	SetDefaultDevice(id string, roles []Role) error
+	RefreshDevices()
+	RefreshSessions()
+	// Subscribe calls fn with every VolumeChange from then on, one at a time.
+	Subscribe(fn func(VolumeChange))
+	// OnSessionsRefreshed calls fn with all of the sessions of a device each time they're looked up again,
+	// this is where new sessions show up. The device might be locked so fn can't use its Sessions.
+	OnSessionsRefreshed(fn func(d Device, sessions []Session))
+	// OnDevicesRefreshed calls fn after the devices were looked up again, the old devices are gone by then.
+	OnDevicesRefreshed(fn func())
+	Cleanup() error
+}
+
+// VolumeChange is published whenever the volume or mute of a device or audio session changes, whether it was
+// Automidically, the system volume mixer, or the application itself that changed it.
+type VolumeChange struct {
+	DeviceID   string `json:"deviceID"`
+	DeviceName string `json:"deviceName"`
+	Flow       string `json:"flow"`
+	// Default is true for the default output & input devices, and the audio sessions on them.
+	Default bool `json:"default"`
+	// Session is the process filename of the audio session, it's empty when the change is to the device itself.
+	Session   string  `json:"session,omitempty"`
+	ProcessID int     `json:"processID,omitempty"`
+	Volume    float32 `json:"volume"`
+	Mute      bool    `json:"mute"`
+	// External is true when the change wasn't made by Automidically.
+	External bool `json:"external"`
+}
+
+// ActiveWindow tells the Router which process is in the foreground for the active special.
+type ActiveWindow interface {
+	ProcessFilename() string
+	ProcessID() int
+}
+
+// currentState returns the volume & mute of a target, from the notifications the backend gets when there are
+// any so there's no need to ask it again.
+func currentState(c Control) (volume float32, mute bool, err error) {
+	if v, m, ok := c.LiveState(); ok {
+		return v, m, nil
+	}
+	if volume, err = c.GetVolumeLevel(); err != nil {
+		return 0, false, err
+	}
+	if mute, err = c.GetMute(); err != nil {
+		return 0, false, err
+	}
+	return volume, mute, nil
+}
@@ -0,0 +1,91 @@
+package audio
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/GregoryDosh/automidically/internal/mixer"
+)
+
+var ErrorNoDefaultDevice = errors.New("no device found to make the default")
+
+// mappingRoles turns the roles of a mapping into Roles.
+func mappingRoles(m *mixer.Mapping) []Role {
+	roles := []Role{}
+	for _, r := range m.Role {
+		switch strings.ToLower(r) {
+		case strings.ToLower(mixer.RoleConsole):
+			roles = append(roles, Console)
+		case strings.ToLower(mixer.RoleMultimedia):
+			roles = append(roles, Multimedia)
+		case strings.ToLower(mixer.RoleCommunications):
+			roles = append(roles, Communications)
+		}
+	}
+	return roles
+}
+
+// switchDefaultDevice makes one of the devices of the mapping the default. With several devices it moves on to
+// the one after the current default, so a button can cycle through them. The Backend then lets everything else
+// know the default changed, which is where the other mappings pick up the new device.
+func (r *Router) switchDefaultDevice(m *mixer.Mapping) {
+	log.Trace("Enter switchDefaultDevice")
+	defer log.Trace("Exit switchDefaultDevice")
+
+	roles := mappingRoles(m)
+	if len(roles) == 0 {
+		return
+	}
+
+	// The ids are collected first so the devices aren't in use while the Backend changes the defaults.
+	next := map[Flow]Device{}
+	r.backend.View(func(devices []Device) {
+		// The devices are cycled through in the order of the mapping, not the order the Backend lists them in.
+		candidates := []Device{}
+		seen := map[string]bool{}
+		for _, name := range m.Device {
+			for _, d := range devices {
+				if m.Patterns.Match(name, d.Name()) && !seen[d.ID()] {
+					candidates = append(candidates, d)
+					seen[d.ID()] = true
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			log.Warnf("%s: %v", ErrorNoDefaultDevice, m.Device)
+			return
+		}
+
+		// Outputs and inputs are cycled separately, so a headset's earphones and microphone can switch together.
+		for _, flow := range []Flow{Output, Input} {
+			flowCandidates := []Device{}
+			for _, d := range candidates {
+				if d.Flow() == flow {
+					flowCandidates = append(flowCandidates, d)
+				}
+			}
+			if len(flowCandidates) == 0 {
+				continue
+			}
+
+			next[flow] = flowCandidates[0]
+			for i, d := range flowCandidates {
+				if d.IsDefault(roles[0]) {
+					next[flow] = flowCandidates[(i+1)%len(flowCandidates)]
+					break
+				}
+			}
+		}
+	})
+
+	for _, flow := range []Flow{Output, Input} {
+		d, ok := next[flow]
+		if !ok {
+			continue
+		}
+		log.Infof("switching default %s device to %s", flow, d.Name())
+		if err := r.backend.SetDefaultDevice(d.ID(), roles); err != nil {
+			log.Errorf("unable to set default %s device: %s", flow, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package audio
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+	"github.com/GregoryDosh/automidically/internal/mixer"
+)
+
+// update is a pending change to a target from a mapping. The volume and encoder ticks are worked out up front
+// so updates can be merged without having to look at the MIDI message again.
+type update struct {
+	mapping     mixer.Mapping
+	value       int
+	volumeLevel float32
+	ticks       int
+}
+
+func newUpdate(m *mixer.Mapping, msg message.Message) update {
+	u := update{
+		mapping: *m,
+		value:   msg.Value,
+	}
+	if m.IsRelative() {
+		u.ticks = m.EncoderTicks(msg.Value)
+	} else {
+		u.volumeLevel = m.VolumeLevel(msg.Value)
+	}
+	return u
+}
+
+// merge tries to fold next into u so only one change has to be made to the target. Volumes are replaced by the
+// latest, encoder ticks are added together, and actions are never merged since every press matters.
+func (u *update) merge(next update) bool {
+	if u.mapping.IsMuteAction() || u.mapping.IsDefaultDeviceAction() || !reflect.DeepEqual(u.mapping, next.mapping) {
+		return false
+	}
+	if u.mapping.IsRelative() {
+		u.ticks += next.ticks
+		u.value = next.value
+		return true
+	}
+	*u = next
+	return true
+}
+
+// workerIdleTimeout is how long a target worker waits for another update before it stops. Targets like
+// window titles can be different every time, so the workers would pile up otherwise.
+const workerIdleTimeout = time.Minute
+
+// targetWorker applies the updates for one target in the order they arrived. Updates that arrive while the
+// worker is busy wait in pending, where they're merged so a fast fader only results in its latest position.
+type targetWorker struct {
+	pending []update
+	signal  chan bool
+	stop    chan bool
+}
+
+// enqueueUpdate hands an update to the worker of the target, starting the worker if this is the first update.
+func (r *Router) enqueueUpdate(key targetKey, u update) {
+	r.workersLock.Lock()
+	defer r.workersLock.Unlock()
+
+	w, ok := r.workers[key]
+	if !ok {
+		w = &targetWorker{
+			signal: make(chan bool, 1),
+			stop:   make(chan bool),
+		}
+		r.workers[key] = w
+		go r.targetWorkerLoop(key, w)
+	}
+
+	if n := len(w.pending); n == 0 || !w.pending[n-1].merge(u) {
+		w.pending = append(w.pending, u)
+	}
+
+	select {
+	case w.signal <- true:
+	default:
+	}
+}
+
+func (r *Router) targetWorkerLoop(key targetKey, w *targetWorker) {
+	log.Tracef("Enter targetWorkerLoop %s %s", key.kind, key.name)
+	defer log.Tracef("Exit targetWorkerLoop %s %s", key.kind, key.name)
+
+	idle := time.NewTimer(r.workerIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-idle.C:
+			// Only stopping if nothing snuck in, the next update for the target starts a new worker.
+			r.workersLock.Lock()
+			if len(w.pending) == 0 {
+				if r.workers[key] == w {
+					delete(r.workers, key)
+				}
+				r.workersLock.Unlock()
+				return
+			}
+			r.workersLock.Unlock()
+		case <-w.signal:
+		}
+
+		r.workersLock.Lock()
+		updates := w.pending
+		w.pending = nil
+		r.workersLock.Unlock()
+
+		for _, u := range updates {
+			r.applyUpdate(key, u)
+		}
+
+		if !idle.Stop() {
+			select {
+			case <-idle.C:
+			default:
+			}
+		}
+		idle.Reset(r.workerIdleTimeout)
+	}
+}
+
+// stopWorkers ends all of the target workers, anything still pending is dropped.
+func (r *Router) stopWorkers() {
+	r.workersLock.Lock()
+	defer r.workersLock.Unlock()
+	for key, w := range r.workers {
+		close(w.stop)
+		delete(r.workers, key)
+	}
+}
@@ -0,0 +1,464 @@
+package audio
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Fake is a Backend that keeps everything in memory, so the mapping logic can be tried out without any real
+// audio devices. Devices & sessions are added with AddDevice and AddSession, and changes made from outside of
+// Automidically, like the system volume mixer would, can be made with SetExternalVolume & SetExternalMute.
+// Volume changes are published right away from whatever goroutine made them.
+type Fake struct {
+	devices             []*FakeDevice
+	onSessionsRefreshed func(Device, []Session)
+	onDevicesRefreshed  func()
+	subscribers         []func(VolumeChange)
+	closed              bool
+	publishLock         sync.Mutex
+	sync.Mutex
+}
+
+// FakeProcess is a process for a FakeSession, or one of its ancestors.
+type FakeProcess struct {
+	ProcessID         int
+	ProcessExecutable string
+	ProcessPath       string
+	Titles            []string
+}
+
+func (p FakeProcess) Pid() int               { return p.ProcessID }
+func (p FakeProcess) Executable() string     { return p.ProcessExecutable }
+func (p FakeProcess) Path() string           { return p.ProcessPath }
+func (p FakeProcess) WindowTitles() []string { return p.Titles }
+
+// fakeControl is the volume & mute of a FakeDevice or FakeSession.
+type fakeControl struct {
+	volume  float32
+	mute    bool
+	expired bool
+	sync.Mutex
+}
+
+func (c *fakeControl) get() (float32, bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.expired {
+		return 0, false, ErrorSessionExpired
+	}
+	return c.volume, c.mute, nil
+}
+
+// set changes the volume and/or mute, it reports if anything actually changed.
+func (c *fakeControl) set(volume *float32, mute *bool) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.expired {
+		return false, ErrorSessionExpired
+	}
+	changed := false
+	if volume != nil {
+		if *volume < 0 || 1 < *volume {
+			return false, fmt.Errorf("invalid volume level %f", *volume)
+		}
+		changed = *volume != c.volume
+		c.volume = *volume
+	}
+	if mute != nil {
+		changed = changed || *mute != c.mute
+		c.mute = *mute
+	}
+	return changed, nil
+}
+
+// FakeDevice is a device of the Fake backend.
+type FakeDevice struct {
+	fake     *Fake
+	id       string
+	name     string
+	flow     Flow
+	defaults map[Role]bool
+	sessions []*FakeSession
+	control  fakeControl
+}
+
+// FakeSession is an audio session of a FakeDevice.
+type FakeSession struct {
+	FakeProcess
+	device      *FakeDevice
+	displayName string
+	system      bool
+	ancestors   []FakeProcess
+	control     fakeControl
+}
+
+// NewFake creates a Fake backend without any devices.
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+// AddDevice adds a device at full volume, it becomes the default of its flow for every role if there isn't one yet.
+func (f *Fake) AddDevice(id, name string, flow Flow) *FakeDevice {
+	f.Lock()
+	d := &FakeDevice{
+		fake:     f,
+		id:       id,
+		name:     name,
+		flow:     flow,
+		defaults: map[Role]bool{},
+		control:  fakeControl{volume: 1},
+	}
+	for _, role := range []Role{Console, Multimedia, Communications} {
+		if f.defaultDevice(flow, role) == nil {
+			d.defaults[role] = true
+		}
+	}
+	f.devices = append(f.devices, d)
+	f.Unlock()
+
+	f.devicesRefreshed()
+	return d
+}
+
+// RemoveDevice takes away the device with the id along with its audio sessions.
+func (f *Fake) RemoveDevice(id string) {
+	f.Lock()
+	for i, d := range f.devices {
+		if d.id == id {
+			d.expireSessions()
+			f.devices = append(f.devices[:i:i], f.devices[i+1:]...)
+			break
+		}
+	}
+	f.Unlock()
+
+	f.devicesRefreshed()
+}
+
+// defaultDevice returns the default device of the flow for the role, or nil if there isn't one.
+// This expects the Fake to be locked already.
+func (f *Fake) defaultDevice(flow Flow, role Role) *FakeDevice {
+	for _, d := range f.devices {
+		if d.flow == flow && d.defaults[role] {
+			return d
+		}
+	}
+	return nil
+}
+
+// View calls fn with all of the devices.
+func (f *Fake) View(fn func(devices []Device)) {
+	f.Lock()
+	devices := make([]Device, len(f.devices))
+	for i, d := range f.devices {
+		devices[i] = d
+	}
+	f.Unlock()
+	fn(devices)
+}
+
+// SetDefaultDevice makes the device with the id the default of its flow for each of the roles.
+func (f *Fake) SetDefaultDevice(id string, roles []Role) error {
+	f.Lock()
+	defer f.Unlock()
+	var device *FakeDevice
+	for _, d := range f.devices {
+		if d.id == id {
+			device = d
+		}
+	}
+	if device == nil {
+		return fmt.Errorf("%w: %s", ErrorDeviceNotFound, id)
+	}
+	for _, role := range roles {
+		if current := f.defaultDevice(device.flow, role); current != nil {
+			delete(current.defaults, role)
+		}
+		device.defaults[role] = true
+	}
+	return nil
+}
+
+// RefreshDevices calls the OnDevicesRefreshed function, and then refreshes the sessions like a real backend would.
+func (f *Fake) RefreshDevices() {
+	f.devicesRefreshed()
+}
+
+// RefreshSessions calls the OnSessionsRefreshed function for every device.
+func (f *Fake) RefreshSessions() {
+	f.Lock()
+	devices := append([]*FakeDevice{}, f.devices...)
+	f.Unlock()
+	for _, d := range devices {
+		d.sessionsRefreshed()
+	}
+}
+
+func (f *Fake) devicesRefreshed() {
+	f.Lock()
+	fn := f.onDevicesRefreshed
+	f.Unlock()
+	if fn != nil {
+		fn()
+	}
+	f.RefreshSessions()
+}
+
+// Subscribe calls fn with every VolumeChange from now on.
+func (f *Fake) Subscribe(fn func(VolumeChange)) {
+	f.Lock()
+	defer f.Unlock()
+	f.subscribers = append(f.subscribers, fn)
+}
+
+// publish hands a change to the subscribers one at a time.
+func (f *Fake) publish(change VolumeChange) {
+	f.Lock()
+	fns := f.subscribers
+	closed := f.closed
+	f.Unlock()
+	if closed {
+		return
+	}
+
+	f.publishLock.Lock()
+	defer f.publishLock.Unlock()
+	for _, fn := range fns {
+		fn(change)
+	}
+}
+
+func (f *Fake) OnSessionsRefreshed(fn func(d Device, sessions []Session)) {
+	f.Lock()
+	defer f.Unlock()
+	f.onSessionsRefreshed = fn
+}
+
+func (f *Fake) OnDevicesRefreshed(fn func()) {
+	f.Lock()
+	defer f.Unlock()
+	f.onDevicesRefreshed = fn
+}
+
+// Cleanup stops any more changes from being published.
+func (f *Fake) Cleanup() error {
+	f.Lock()
+	defer f.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (d *FakeDevice) ID() string   { return d.id }
+func (d *FakeDevice) Name() string { return d.name }
+func (d *FakeDevice) Flow() Flow   { return d.flow }
+
+func (d *FakeDevice) IsDefault(role Role) bool {
+	d.fake.Lock()
+	defer d.fake.Unlock()
+	return d.defaults[role]
+}
+
+func (d *FakeDevice) Sessions() []Session {
+	d.fake.Lock()
+	defer d.fake.Unlock()
+	sessions := make([]Session, len(d.sessions))
+	for i, s := range d.sessions {
+		sessions[i] = s
+	}
+	return sessions
+}
+
+func (d *FakeDevice) GetVolumeLevel() (float32, error) {
+	v, _, err := d.control.get()
+	return v, err
+}
+
+func (d *FakeDevice) GetMute() (bool, error) {
+	_, m, err := d.control.get()
+	return m, err
+}
+
+func (d *FakeDevice) SetVolumeLevel(v float32) error {
+	return d.set(&v, nil, false)
+}
+
+func (d *FakeDevice) SetMute(m bool) error {
+	return d.set(nil, &m, false)
+}
+
+func (d *FakeDevice) LiveState() (float32, bool, bool) {
+	v, m, err := d.control.get()
+	return v, m, err == nil
+}
+
+// SetExternalVolume changes the volume of the device like the system volume mixer would.
+func (d *FakeDevice) SetExternalVolume(v float32) error {
+	return d.set(&v, nil, true)
+}
+
+// SetExternalMute changes the mute of the device like the system volume mixer would.
+func (d *FakeDevice) SetExternalMute(m bool) error {
+	return d.set(nil, &m, true)
+}
+
+func (d *FakeDevice) set(volume *float32, mute *bool, external bool) error {
+	changed, err := d.control.set(volume, mute)
+	if err != nil || !changed {
+		return err
+	}
+	d.fake.publish(d.volumeChange(nil, external))
+	return nil
+}
+
+// volumeChange describes the current state of the device, or one of its sessions.
+func (d *FakeDevice) volumeChange(s *FakeSession, external bool) VolumeChange {
+	change := VolumeChange{
+		DeviceID:   d.id,
+		DeviceName: d.name,
+		Flow:       d.flow.String(),
+		Default:    d.IsDefault(Console),
+		External:   external,
+	}
+	if s == nil {
+		change.Volume, change.Mute, _ = d.control.get()
+		return change
+	}
+	change.Session = s.ProcessExecutable
+	change.ProcessID = s.ProcessID
+	change.Volume, change.Mute, _ = s.control.get()
+	return change
+}
+
+// AddSession adds an audio session at full volume for the process. The ancestors are the parent, grandparent,
+// etc. of the process, nearest first.
+func (d *FakeDevice) AddSession(p FakeProcess, ancestors ...FakeProcess) *FakeSession {
+	s := &FakeSession{
+		FakeProcess: p,
+		device:      d,
+		ancestors:   ancestors,
+		control:     fakeControl{volume: 1},
+	}
+	d.addSession(s)
+	return s
+}
+
+// AddSystemSession adds the audio session for the system sounds.
+func (d *FakeDevice) AddSystemSession() *FakeSession {
+	s := &FakeSession{
+		device:  d,
+		system:  true,
+		control: fakeControl{volume: 1},
+	}
+	d.addSession(s)
+	return s
+}
+
+func (d *FakeDevice) addSession(s *FakeSession) {
+	d.fake.Lock()
+	d.sessions = append(d.sessions, s)
+	d.fake.Unlock()
+	d.sessionsRefreshed()
+}
+
+// RemoveSession takes away the audio session, using it afterwards returns ErrorSessionExpired.
+func (d *FakeDevice) RemoveSession(s *FakeSession) {
+	d.fake.Lock()
+	for i, as := range d.sessions {
+		if as == s {
+			d.sessions = append(d.sessions[:i:i], d.sessions[i+1:]...)
+			break
+		}
+	}
+	d.fake.Unlock()
+	s.expire()
+	d.sessionsRefreshed()
+}
+
+// expireSessions expires all of the audio sessions of the device.
+// This expects the Fake to be locked already.
+func (d *FakeDevice) expireSessions() {
+	for _, s := range d.sessions {
+		s.expire()
+	}
+	d.sessions = nil
+}
+
+func (d *FakeDevice) sessionsRefreshed() {
+	d.fake.Lock()
+	fn := d.fake.onSessionsRefreshed
+	d.fake.Unlock()
+	if fn != nil {
+		fn(d, d.Sessions())
+	}
+}
+
+// SetDisplayName sets the name some applications give their audio sessions.
+func (s *FakeSession) SetDisplayName(name string) {
+	s.device.fake.Lock()
+	defer s.device.fake.Unlock()
+	s.displayName = name
+}
+
+func (s *FakeSession) DisplayName() string {
+	s.device.fake.Lock()
+	defer s.device.fake.Unlock()
+	return s.displayName
+}
+
+func (s *FakeSession) IsSystem() bool { return s.system }
+
+func (s *FakeSession) Ancestors() []Process {
+	ancestors := make([]Process, len(s.ancestors))
+	for i, p := range s.ancestors {
+		ancestors[i] = p
+	}
+	return ancestors
+}
+
+func (s *FakeSession) GetVolumeLevel() (float32, error) {
+	v, _, err := s.control.get()
+	return v, err
+}
+
+func (s *FakeSession) GetMute() (bool, error) {
+	_, m, err := s.control.get()
+	return m, err
+}
+
+func (s *FakeSession) SetVolumeLevel(v float32) error {
+	return s.set(&v, nil, false)
+}
+
+func (s *FakeSession) SetMute(m bool) error {
+	return s.set(nil, &m, false)
+}
+
+func (s *FakeSession) LiveState() (float32, bool, bool) {
+	v, m, err := s.control.get()
+	return v, m, err == nil
+}
+
+// SetExternalVolume changes the volume of the audio session like the system volume mixer, or the application, would.
+func (s *FakeSession) SetExternalVolume(v float32) error {
+	return s.set(&v, nil, true)
+}
+
+// SetExternalMute changes the mute of the audio session like the system volume mixer, or the application, would.
+func (s *FakeSession) SetExternalMute(m bool) error {
+	return s.set(nil, &m, true)
+}
+
+func (s *FakeSession) set(volume *float32, mute *bool, external bool) error {
+	changed, err := s.control.set(volume, mute)
+	if err != nil || !changed {
+		return err
+	}
+	s.device.fake.publish(s.device.volumeChange(s, external))
+	return nil
+}
+
+func (s *FakeSession) expire() {
+	s.control.Lock()
+	defer s.control.Unlock()
+	s.control.expired = true
+}
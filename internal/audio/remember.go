@@ -0,0 +1,110 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/GregoryDosh/automidically/internal/mixer"
+	"github.com/GregoryDosh/automidically/internal/pattern"
+)
+
+// rememberVolume keeps the last volume set on an audio session target, so sessions that show up later
+// (like a game launched after its fader was moved) can start at the same volume.
+func (r *Router) rememberVolume(key targetKey, v float32) {
+	if !key.isSession() {
+		return
+	}
+	r.rememberLock.Lock()
+	defer r.rememberLock.Unlock()
+	r.remembered[key] = v
+}
+
+// SetMixerMappings tells the Router about the current mixer mappings so it can pick up their initialVolume,
+// and forget the volumes remembered for targets that no mapping has anymore. The takeover states of mappings
+// that were changed or removed are forgotten too, so a new mapping never picks up where another one left off.
+func (r *Router) SetMixerMappings(mappings []mixer.Mapping) {
+	ids := map[string]bool{}
+	for i := range mappings {
+		ids[mappings[i].ID] = true
+	}
+	r.takeoverLock.Lock()
+	for tk := range r.takeover {
+		if !ids[tk.mapping] {
+			delete(r.takeover, tk)
+		}
+	}
+	r.takeoverLock.Unlock()
+
+	r.rememberLock.Lock()
+	defer r.rememberLock.Unlock()
+
+	targets := map[targetKey]bool{}
+	r.initialVolumes = map[targetKey]float32{}
+	r.sessionPatterns = pattern.Set{}
+	for i := range mappings {
+		m := &mappings[i]
+		for raw, p := range pattern.NewSet(m.Filename, m.Path, m.Title, m.DisplayName, []string{m.SessionDevice}) {
+			r.sessionPatterns[raw] = p
+		}
+		for _, key := range mappingTargetKeys(m) {
+			if !key.isSession() {
+				continue
+			}
+			targets[key] = true
+			if m.InitialVolume != nil && !m.IsMuteAction() && !m.IsDefaultDeviceAction() {
+				r.initialVolumes[key] = *m.InitialVolume
+			}
+		}
+	}
+	for key := range r.remembered {
+		if !targets[key] {
+			delete(r.remembered, key)
+		}
+	}
+}
+
+// sessionID tells audio sessions apart between refreshes, a process that's restarted gets a new one.
+func sessionID(s Session) string {
+	return fmt.Sprintf("%d/%s/%s", s.Pid(), s.Executable(), s.DisplayName())
+}
+
+// onSessionsRefreshed looks for audio sessions that weren't there at the last refresh of the device and gives
+// them the remembered volume of their target, or the initialVolume of the mapping when nothing was remembered yet.
+// The sessions already there the first time a device is seen are left alone.
+func (r *Router) onSessionsRefreshed(d Device, sessions []Session) {
+	r.rememberLock.Lock()
+	defer r.rememberLock.Unlock()
+
+	known, primed := r.knownSessions[d.ID()]
+	current := map[string]bool{}
+	for _, s := range sessions {
+		id := sessionID(s)
+		current[id] = true
+		if !primed || known[id] {
+			continue
+		}
+		r.applyRememberedVolume(d, s)
+	}
+	r.knownSessions[d.ID()] = current
+}
+
+// applyRememberedVolume sets the volume of a new audio session from the first target it matches.
+// This expects the rememberLock to be held already.
+func (r *Router) applyRememberedVolume(d Device, s Session) {
+	apply := func(volumes map[targetKey]float32) bool {
+		for key, v := range volumes {
+			if !sessionDeviceMatches(key, d, r.sessionPatterns) || !sessionMatcher(key, r.sessionPatterns)(s) {
+				continue
+			}
+			log.Debugf("setting new audio session %s to %.2f for %s %s", s.Executable(), v, key.kind, key.name)
+			if err := s.SetVolumeLevel(v); err != nil && !errors.Is(err, ErrorSessionExpired) {
+				log.Error(err)
+			}
+			return true
+		}
+		return false
+	}
+	if !apply(r.remembered) {
+		apply(r.initialVolumes)
+	}
+}
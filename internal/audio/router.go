@@ -0,0 +1,235 @@
+package audio
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+	"github.com/GregoryDosh/automidically/internal/mixer"
+	"github.com/GregoryDosh/automidically/internal/pattern"
+)
+
+// Router is where the mixer mappings meet a Backend. It works out which devices & audio sessions a MIDI message
+// is for and makes the changes through the Backend, so none of this depends on the platform.
+type Router struct {
+	backend      Backend
+	active       ActiveWindow
+	takeover     map[takeoverKey]*mixer.TakeoverState
+	takeoverLock sync.Mutex
+	workers      map[targetKey]*targetWorker
+	workersLock  sync.Mutex
+	// workerIdleTimeout is how long target workers wait for an update before stopping, the tests make it shorter.
+	workerIdleTimeout time.Duration
+	remembered        map[targetKey]float32
+	initialVolumes    map[targetKey]float32
+	knownSessions     map[string]map[string]bool
+	// sessionPatterns are the compiled patterns of every mapping, for matching new audio sessions to their targets.
+	sessionPatterns pattern.Set
+	rememberLock    sync.Mutex
+}
+
+// takeoverKey pairs a mapping with one of its targets so each of them can be picked up separately.
+type takeoverKey struct {
+	mapping string
+	target  Control
+}
+
+// HandleMIDIMessage will take a *mixer.Mapping, and the decoded MIDI message msg, to peform the necessary logic
+// of refreshing devices, setting volumes of audio sessions, devices, and other potential scenarios.
+// Changes to the targets are queued up per target and applied in order, so this doesn't block on the Backend.
+func (r *Router) HandleMIDIMessage(m *mixer.Mapping, msg message.Message) {
+	if !m.Matches(msg) {
+		return
+	}
+
+	// refreshDevices & refreshSessions are actions instead of targets, so handle them here.
+	for _, s := range m.Special {
+		if strings.EqualFold(s, "refreshDevices") {
+			r.backend.RefreshDevices()
+		}
+		if strings.EqualFold(s, "refreshSessions") {
+			r.backend.RefreshSessions()
+		}
+	}
+
+	// Switching the default device is about the devices as a whole, rather than a change to each of them.
+	if m.IsDefaultDeviceAction() {
+		if m.Pressed(msg.Value) {
+			r.enqueueUpdate(targetKey{kind: targetDefaultDevice}, newUpdate(m, msg))
+		}
+		return
+	}
+
+	// Toggling is decided once for all of the targets, otherwise ones that were already muted would flip the
+	// other way and they'd never line up.
+	if m.IsToggleMuteAction() {
+		if m.Pressed(msg.Value) {
+			r.enqueueUpdate(targetKey{kind: targetToggleMute, mapping: m.ID}, newUpdate(m, msg))
+		}
+		return
+	}
+
+	u := newUpdate(m, msg)
+	for _, key := range mappingTargetKeys(m) {
+		r.enqueueUpdate(key, u)
+	}
+}
+
+// applyUpdate performs the change described by an update to all of the devices and audio sessions of a single target.
+// This is only called from the target's worker so the updates to a target happen one at a time, in order.
+func (r *Router) applyUpdate(key targetKey, u update) {
+	m := &u.mapping
+	if key.kind == targetDefaultDevice {
+		r.switchDefaultDevice(m)
+		return
+	}
+	if key.kind == targetToggleMute {
+		r.toggleMute(m, u.value)
+		return
+	}
+
+	setVolumeLevel := func(c Control, v float32) error {
+		if err := c.SetVolumeLevel(v); err != nil {
+			return err
+		}
+		r.rememberVolume(key, v)
+		return nil
+	}
+
+	r.backend.View(func(devices []Device) {
+		r.forEachTarget(devices, []targetKey{key}, m.Patterns, func(c Control) error {
+			if m.IsMuteAction() {
+				_, current, err := currentState(c)
+				if err != nil {
+					return err
+				}
+				mute, ok := m.MuteState(u.value, current)
+				if !ok || mute == current {
+					return nil
+				}
+				return c.SetMute(mute)
+			}
+			// Relative encoders step from wherever the target currently is.
+			if m.IsRelative() {
+				current, _, err := currentState(c)
+				if err != nil {
+					return err
+				}
+				return setVolumeLevel(c, m.StepVolumeLevel(current, u.ticks))
+			}
+			// Soft takeover needs to compare the fader with where the target currently is, which keeps up with
+			// changes made in the system volume mixer or the application.
+			if m.HasTakeover() {
+				current, _, err := currentState(c)
+				if err != nil {
+					return err
+				}
+				next, ok := r.takeoverState(m, c).Next(m, current, u.volumeLevel)
+				if !ok {
+					return nil
+				}
+				return setVolumeLevel(c, next)
+			}
+			return setVolumeLevel(c, u.volumeLevel)
+		})
+	})
+}
+
+// toggleMute mutes all of the targets of the mapping, unless every one of them is already muted in which case
+// they're all unmuted.
+func (r *Router) toggleMute(m *mixer.Mapping, value int) {
+	r.backend.View(func(devices []Device) {
+		targets := []Control{}
+		muted := []bool{}
+		r.forEachTarget(devices, mappingTargetKeys(m), m.Patterns, func(c Control) error {
+			_, current, err := currentState(c)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, c)
+			muted = append(muted, current)
+			return nil
+		})
+		if len(targets) == 0 {
+			return
+		}
+
+		allMuted := true
+		for _, current := range muted {
+			allMuted = allMuted && current
+		}
+		mute, ok := m.MuteState(value, allMuted)
+		if !ok {
+			return
+		}
+		for i, c := range targets {
+			if muted[i] == mute {
+				continue
+			}
+			if err := c.SetMute(mute); err != nil && !errors.Is(err, ErrorSessionExpired) {
+				log.Error(err)
+			}
+		}
+	})
+}
+
+// takeoverState returns the soft takeover state of a mapping for one of its targets, starting a new one if needed.
+func (r *Router) takeoverState(m *mixer.Mapping, c Control) *mixer.TakeoverState {
+	r.takeoverLock.Lock()
+	defer r.takeoverLock.Unlock()
+	tk := takeoverKey{mapping: m.ID, target: c}
+	state, ok := r.takeover[tk]
+	if !ok {
+		state = &mixer.TakeoverState{}
+		r.takeover[tk] = state
+	}
+	return state
+}
+
+// resetTakeover forgets the takeover states, they refer to devices & sessions that are gone after a refresh.
+func (r *Router) resetTakeover() {
+	r.takeoverLock.Lock()
+	defer r.takeoverLock.Unlock()
+	r.takeover = map[takeoverKey]*mixer.TakeoverState{}
+}
+
+// RefreshDevices has the Backend look up all of the devices, and their audio sessions, again.
+func (r *Router) RefreshDevices() {
+	r.backend.RefreshDevices()
+}
+
+// RefreshSessions has the Backend look up the audio sessions of all of the devices again.
+func (r *Router) RefreshSessions() {
+	r.backend.RefreshSessions()
+}
+
+// Subscribe calls fn with every VolumeChange of the Backend from now on.
+func (r *Router) Subscribe(fn func(VolumeChange)) {
+	r.backend.Subscribe(fn)
+}
+
+// Cleanup stops all of the target workers before cleaning up the Backend, anything still pending is dropped.
+func (r *Router) Cleanup() error {
+	r.stopWorkers()
+	return r.backend.Cleanup()
+}
+
+// New creates a Router for the backend. The active window is used for the active special, it can be nil if the
+// platform doesn't have one.
+func New(backend Backend, active ActiveWindow) *Router {
+	r := &Router{
+		backend:           backend,
+		active:            active,
+		takeover:          map[takeoverKey]*mixer.TakeoverState{},
+		workers:           map[targetKey]*targetWorker{},
+		workerIdleTimeout: workerIdleTimeout,
+		remembered:        map[targetKey]float32{},
+		initialVolumes:    map[targetKey]float32{},
+		knownSessions:     map[string]map[string]bool{},
+	}
+	backend.OnSessionsRefreshed(r.onSessionsRefreshed)
+	backend.OnDevicesRefreshed(r.resetTakeover)
+	return r
+}
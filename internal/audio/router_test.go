@@ -0,0 +1,387 @@
+package audio
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+	"github.com/GregoryDosh/automidically/internal/mixer"
+	"github.com/GregoryDosh/automidically/internal/pattern"
+	"gopkg.in/yaml.v3"
+)
+
+// fixture is a Fake with a few devices & audio sessions, and a Router for it.
+type fixture struct {
+	fake       *Fake
+	router     *Router
+	speakers   *FakeDevice
+	headphones *FakeDevice
+	mic        *FakeDevice
+	browser    *FakeSession
+	game       *FakeSession
+	chat       *FakeSession
+	system     *FakeSession
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	fx := &fixture{fake: NewFake()}
+	fx.router = New(fx.fake, nil)
+	t.Cleanup(func() {
+		fx.router.Cleanup()
+	})
+
+	fx.speakers = fx.fake.AddDevice("1", "Speakers", Output)
+	fx.headphones = fx.fake.AddDevice("2", "Headphones", Output)
+	fx.mic = fx.fake.AddDevice("3", "Microphone", Input)
+	fx.browser = fx.speakers.AddSession(
+		FakeProcess{ProcessID: 10, ProcessExecutable: "browser_renderer.exe", ProcessPath: `C:\Browser\browser_renderer.exe`, Titles: []string{"Videos - Browser"}},
+		FakeProcess{ProcessID: 9, ProcessExecutable: "browser.exe"},
+	)
+	fx.game = fx.headphones.AddSession(FakeProcess{ProcessID: 20, ProcessExecutable: "game.exe", ProcessPath: `D:\Games\game.exe`})
+	fx.chat = fx.speakers.AddSession(FakeProcess{ProcessID: 30, ProcessExecutable: "chat.exe"})
+	fx.chat.SetDisplayName("Voice Chat")
+	fx.system = fx.speakers.AddSystemSession()
+	return fx
+}
+
+func (fx *fixture) handle(t *testing.T, mapping string, value int) *mixer.Mapping {
+	t.Helper()
+	m := mappingFromYAML(t, mapping)
+	fx.router.HandleMIDIMessage(m, message.Message{Kind: message.ControlChange, Channel: 1, Number: m.Cc, Value: value})
+	return m
+}
+
+func mappingFromYAML(t *testing.T, s string) *mixer.Mapping {
+	t.Helper()
+	var m mixer.Mapping
+	if err := yaml.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	m.CompilePatterns()
+	return &m
+}
+
+// waitFor polls until ok is true, the changes are made by the target workers so they take a moment.
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 2)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// settle gives the target workers a moment, for checking nothing else changed.
+func settle() {
+	time.Sleep(time.Millisecond * 50)
+}
+
+func volumeIs(c Control, expect float32) func() bool {
+	return func() bool {
+		v, err := c.GetVolumeLevel()
+		return err == nil && math.Abs(float64(v-expect)) < 0.001
+	}
+}
+
+func muteIs(c Control, expect bool) func() bool {
+	return func() bool {
+		m, err := c.GetMute()
+		return err == nil && m == expect
+	}
+}
+
+func TestRouterVolume(t *testing.T) {
+	tests := []struct {
+		name      string
+		mapping   string
+		value     int
+		expect    float32
+		target    func(fx *fixture) Control
+		untouched func(fx *fixture) Control
+	}{
+		{"filename", "cc: 1\nfilename: game.exe", 0, 0, func(fx *fixture) Control { return fx.game }, func(fx *fixture) Control { return fx.browser }},
+		{"filename ignores case", "cc: 1\nfilename: GAME.EXE", 0, 0, func(fx *fixture) Control { return fx.game }, nil},
+		{"filename glob", "cc: 1\nfilename: glob:browser_*.exe", 0, 0, func(fx *fixture) Control { return fx.browser }, func(fx *fixture) Control { return fx.game }},
+		{"filename regex", "cc: 1\nfilename: regex:^ga+me\\.exe$", 0, 0, func(fx *fixture) Control { return fx.game }, nil},
+		{"process tree", "cc: 1\nfilename: browser.exe\nprocessTree: true", 0, 0, func(fx *fixture) Control { return fx.browser }, nil},
+		{"without process tree", "cc: 1\nfilename: browser.exe", 0, 1, func(fx *fixture) Control { return fx.browser }, nil},
+		{"path", "cc: 1\npath: glob:D:\\Games\\*", 0, 0, func(fx *fixture) Control { return fx.game }, func(fx *fixture) Control { return fx.browser }},
+		{"title", "cc: 1\ntitle: contains:videos", 0, 0, func(fx *fixture) Control { return fx.browser }, func(fx *fixture) Control { return fx.chat }},
+		{"display name", "cc: 1\ndisplayName: Voice Chat", 0, 0, func(fx *fixture) Control { return fx.chat }, func(fx *fixture) Control { return fx.browser }},
+		{"system", "cc: 1\nspecial: system", 0, 0, func(fx *fixture) Control { return fx.system }, func(fx *fixture) Control { return fx.chat }},
+		{"output", "cc: 1\nspecial: output", 0, 0, func(fx *fixture) Control { return fx.speakers }, func(fx *fixture) Control { return fx.headphones }},
+		{"input", "cc: 1\nspecial: input", 0, 0, func(fx *fixture) Control { return fx.mic }, func(fx *fixture) Control { return fx.speakers }},
+		{"device", "cc: 1\ndevice: contains:phones", 0, 0, func(fx *fixture) Control { return fx.headphones }, func(fx *fixture) Control { return fx.speakers }},
+		{"session device", "cc: 1\nfilename: game.exe\nsessionDevice: Speakers", 0, 1, func(fx *fixture) Control { return fx.game }, nil},
+		{"session device default", "cc: 1\nfilename: glob:*.exe\nsessionDevice: default", 0, 0, func(fx *fixture) Control { return fx.chat }, func(fx *fixture) Control { return fx.game }},
+		{"volume range", "cc: 1\nfilename: game.exe\nvolumeMin: 0.2\nvolumeMax: 0.6", 127, 0.6, func(fx *fixture) Control { return fx.game }, nil},
+		{"hardware range", "cc: 1\nfilename: game.exe\nhardwareMin: 27\nhardwareMax: 127", 77, 0.5, func(fx *fixture) Control { return fx.game }, nil},
+		{"curve", "cc: 1\nfilename: game.exe\nhardwareMax: 100\ncurve: exponential", 50, 0.25, func(fx *fixture) Control { return fx.game }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fx := newFixture(t)
+			fx.handle(t, tt.mapping, tt.value)
+			if tt.expect == 1 {
+				// Everything starts at full volume, so there's no change to wait for.
+				settle()
+			}
+			waitFor(t, "the target volume", volumeIs(tt.target(fx), tt.expect))
+			if tt.untouched != nil {
+				settle()
+				if !volumeIs(tt.untouched(fx), 1)() {
+					t.Error("a control that isn't a target was changed")
+				}
+			}
+		})
+	}
+}
+
+func TestRouterMute(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		start  bool
+		value  int
+		expect bool
+	}{
+		{"mute pressed", "mute", false, 127, true},
+		{"mute released", "mute", false, 0, false},
+		{"unmute pressed", "unmute", true, 127, false},
+		{"unmute released", "unmute", true, 0, true},
+		{"setMute on", "setMute", false, 127, true},
+		{"setMute off", "setMute", true, 0, false},
+		{"holdMute held", "holdMute", false, 64, true},
+		{"holdMute let go", "holdMute", true, 63, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fx := newFixture(t)
+			if err := fx.game.SetExternalMute(tt.start); err != nil {
+				t.Fatal(err)
+			}
+			fx.handle(t, "cc: 1\nfilename: game.exe\naction: "+tt.action, tt.value)
+			settle()
+			waitFor(t, "the mute state", muteIs(fx.game, tt.expect))
+			if v, _ := fx.game.GetVolumeLevel(); v != 1 {
+				t.Errorf("volume changed to %f by a mute action", v)
+			}
+		})
+	}
+}
+
+func TestRouterToggleMute(t *testing.T) {
+	fx := newFixture(t)
+	mapping := "cc: 1\nfilename: [game.exe, chat.exe]\naction: toggleMute"
+	if err := fx.chat.SetExternalMute(true); err != nil {
+		t.Fatal(err)
+	}
+
+	// With only some of them muted, all of them get muted.
+	fx.handle(t, mapping, 127)
+	waitFor(t, "game to be muted", muteIs(fx.game, true))
+	settle()
+	if !muteIs(fx.chat, true)() {
+		t.Error("chat should stay muted")
+	}
+
+	// Letting go of the button doesn't do anything.
+	fx.handle(t, mapping, 0)
+	settle()
+	if !muteIs(fx.game, true)() || !muteIs(fx.chat, true)() {
+		t.Error("releasing the button shouldn't change anything")
+	}
+
+	// All of them are muted now, so they're all unmuted.
+	fx.handle(t, mapping, 127)
+	waitFor(t, "game to be unmuted", muteIs(fx.game, false))
+	waitFor(t, "chat to be unmuted", muteIs(fx.chat, false))
+}
+
+func TestRouterDefaultDevice(t *testing.T) {
+	fx := newFixture(t)
+	mapping := "cc: 1\naction: defaultDevice\ndevice: [Speakers, Headphones]"
+	isDefault := func(d *FakeDevice) func() bool {
+		return func() bool {
+			return d.IsDefault(Console) && d.IsDefault(Multimedia)
+		}
+	}
+
+	fx.handle(t, mapping, 127)
+	waitFor(t, "headphones to be the default", isDefault(fx.headphones))
+	if fx.headphones.IsDefault(Communications) {
+		t.Error("the communications default should be left alone")
+	}
+	fx.handle(t, mapping, 0)
+	settle()
+	if !isDefault(fx.headphones)() {
+		t.Error("releasing the button shouldn't change the default")
+	}
+	fx.handle(t, mapping, 127)
+	waitFor(t, "speakers to be the default again", isDefault(fx.speakers))
+	if !fx.mic.IsDefault(Console) {
+		t.Error("the input should stay the default")
+	}
+}
+
+func TestRouterEncoder(t *testing.T) {
+	fx := newFixture(t)
+	if err := fx.game.SetExternalVolume(0.5); err != nil {
+		t.Fatal(err)
+	}
+	mapping := "cc: 1\nfilename: game.exe\nencoder: twosComplement\nstep: 0.1"
+	fx.handle(t, mapping, 1)
+	fx.handle(t, mapping, 1)
+	waitFor(t, "two steps up", volumeIs(fx.game, 0.7))
+	fx.handle(t, mapping, 127)
+	waitFor(t, "a step down", volumeIs(fx.game, 0.6))
+}
+
+func TestRouterTakeoverPickup(t *testing.T) {
+	fx := newFixture(t)
+	if err := fx.game.SetExternalVolume(0.5); err != nil {
+		t.Fatal(err)
+	}
+	mapping := "cc: 1\nfilename: game.exe\ntakeover: pickup"
+
+	// The fader is below the volume, so it's ignored until it crosses it.
+	fx.handle(t, mapping, 10)
+	fx.handle(t, mapping, 30)
+	settle()
+	if !volumeIs(fx.game, 0.5)() {
+		t.Error("volume shouldn't change before the fader picks it up")
+	}
+	fx.handle(t, mapping, 127)
+	waitFor(t, "the fader to take over", volumeIs(fx.game, 1))
+}
+
+func TestRouterTakeoverForgotten(t *testing.T) {
+	fx := newFixture(t)
+	if err := fx.game.SetExternalVolume(0.5); err != nil {
+		t.Fatal(err)
+	}
+	mapping := "cc: 1\nfilename: game.exe\ntakeover: pickup"
+	fx.handle(t, mapping, 10)
+	settle()
+	m := fx.handle(t, mapping, 127)
+	waitFor(t, "the fader to take over", volumeIs(fx.game, 1))
+	takeovers := func() int {
+		fx.router.takeoverLock.Lock()
+		defer fx.router.takeoverLock.Unlock()
+		return len(fx.router.takeover)
+	}
+	if takeovers() != 1 {
+		t.Fatalf("expected a takeover state, found %d", takeovers())
+	}
+
+	// The state is kept while the mapping is still around, and dropped once it isn't.
+	fx.router.SetMixerMappings([]mixer.Mapping{*m})
+	if takeovers() != 1 {
+		t.Error("the takeover state of an unchanged mapping should be kept")
+	}
+	changed := *m
+	changed.ID = "changed"
+	fx.router.SetMixerMappings([]mixer.Mapping{changed})
+	if takeovers() != 0 {
+		t.Error("the takeover state of a changed mapping should be forgotten")
+	}
+}
+
+func TestRouterRememberedVolume(t *testing.T) {
+	fx := newFixture(t)
+	m := mappingFromYAML(t, "cc: 1\nfilename: game.exe")
+	fx.router.SetMixerMappings([]mixer.Mapping{*m})
+	fx.router.HandleMIDIMessage(m, message.Message{Kind: message.ControlChange, Channel: 1, Number: 1, Value: 0})
+	waitFor(t, "the game volume", volumeIs(fx.game, 0))
+
+	// A new game session, like after restarting it, starts at the same volume.
+	fx.headphones.RemoveSession(fx.game)
+	restarted := fx.speakers.AddSession(FakeProcess{ProcessID: 21, ProcessExecutable: "game.exe"})
+	if !volumeIs(restarted, 0)() {
+		t.Error("new session should get the remembered volume")
+	}
+
+	// Once no mapping has the target, it's forgotten.
+	fx.router.SetMixerMappings(nil)
+	another := fx.speakers.AddSession(FakeProcess{ProcessID: 22, ProcessExecutable: "game.exe"})
+	if !volumeIs(another, 1)() {
+		t.Error("volume shouldn't be remembered without a mapping")
+	}
+}
+
+func TestRouterInitialVolume(t *testing.T) {
+	fx := newFixture(t)
+	m := mappingFromYAML(t, "cc: 1\nfilename: game.exe\ninitialVolume: 0.3")
+	fx.router.SetMixerMappings([]mixer.Mapping{*m})
+	if !volumeIs(fx.game, 1)() {
+		t.Error("sessions that were already there should be left alone")
+	}
+	s := fx.speakers.AddSession(FakeProcess{ProcessID: 21, ProcessExecutable: "game.exe"})
+	if !volumeIs(s, 0.3)() {
+		t.Error("new session should start at the initial volume")
+	}
+}
+
+func TestRouterSetTargetState(t *testing.T) {
+	fx := newFixture(t)
+	m := mappingFromYAML(t, "filename: game.exe\nspecial: output")
+	volume := float32(0.25)
+	mute := true
+	if err := fx.router.SetTargetState(m, &volume, &mute); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "game volume", volumeIs(fx.game, 0.25))
+	waitFor(t, "game mute", muteIs(fx.game, true))
+	waitFor(t, "output volume", volumeIs(fx.speakers, 0.25))
+	waitFor(t, "output mute", muteIs(fx.speakers, true))
+
+	if err := fx.router.SetTargetState(mappingFromYAML(t, "cc: 1"), &volume, nil); err != ErrorNoTargets {
+		t.Errorf("expected %s, got %v", ErrorNoTargets, err)
+	}
+}
+
+func TestRouterGetTargetState(t *testing.T) {
+	fx := newFixture(t)
+	if err := fx.game.SetExternalVolume(0.4); err != nil {
+		t.Fatal(err)
+	}
+	if err := fx.game.SetExternalMute(true); err != nil {
+		t.Fatal(err)
+	}
+	volume, mute, ok := fx.router.GetTargetState([]string{"glob:game*"}, nil, nil, pattern.NewSet([]string{"glob:game*"}))
+	if !ok || volume != 0.4 || !mute {
+		t.Errorf("got %f %t %t, expected the game's state", volume, mute, ok)
+	}
+	if _, _, ok := fx.router.GetTargetState([]string{"missing.exe"}, nil, nil, nil); ok {
+		t.Error("missing target shouldn't be found")
+	}
+}
+
+func TestRouterIdleWorkers(t *testing.T) {
+	fx := newFixture(t)
+	fx.router.workerIdleTimeout = time.Millisecond * 20
+	workers := func() int {
+		fx.router.workersLock.Lock()
+		defer fx.router.workersLock.Unlock()
+		return len(fx.router.workers)
+	}
+	fx.handle(t, "cc: 1\nfilename: [game.exe, chat.exe]", 0)
+	if n := workers(); n != 2 {
+		t.Errorf("expected a worker for each target, found %d", n)
+	}
+	waitFor(t, "the workers to stop", func() bool { return workers() == 0 })
+	if !volumeIs(fx.game, 0)() || !volumeIs(fx.chat, 0)() {
+		t.Error("updates should be applied before the workers stop")
+	}
+
+	// A new update starts the worker again.
+	fx.handle(t, "cc: 1\nfilename: game.exe", 127)
+	waitFor(t, "game volume", volumeIs(fx.game, 1))
+}
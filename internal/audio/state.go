@@ -0,0 +1,107 @@
+package audio
+
+import (
+	"errors"
+
+	"github.com/GregoryDosh/automidically/internal/mixer"
+)
+
+var ErrorNoTargets = errors.New("no devices or audio sessions to change")
+
+// DeviceState is a snapshot of a device and its audio sessions, for showing to people or other programs.
+type DeviceState struct {
+	ID                    string         `json:"id"`
+	Name                  string         `json:"name"`
+	Flow                  string         `json:"flow"`
+	Default               bool           `json:"default"`
+	DefaultCommunications bool           `json:"defaultCommunications"`
+	Volume                float32        `json:"volume"`
+	Mute                  bool           `json:"mute"`
+	Sessions              []SessionState `json:"sessions"`
+}
+
+// SessionState is a snapshot of an audio session.
+type SessionState struct {
+	ProcessID   int     `json:"processID"`
+	Executable  string  `json:"executable"`
+	Path        string  `json:"path"`
+	DisplayName string  `json:"displayName"`
+	System      bool    `json:"system"`
+	Volume      float32 `json:"volume"`
+	Mute        bool    `json:"mute"`
+}
+
+// Devices returns the current state of all of the devices along with their audio sessions. Anything that goes away
+// while it's being looked at is left out.
+func (r *Router) Devices() []DeviceState {
+	states := []DeviceState{}
+	r.backend.View(func(devices []Device) {
+		for _, d := range devices {
+			volume, mute, err := currentState(d)
+			if err != nil {
+				log.Debug(err)
+				continue
+			}
+			state := DeviceState{
+				ID:                    d.ID(),
+				Name:                  d.Name(),
+				Flow:                  d.Flow().String(),
+				Default:               d.IsDefault(Console),
+				DefaultCommunications: d.IsDefault(Communications),
+				Volume:                volume,
+				Mute:                  mute,
+				Sessions:              []SessionState{},
+			}
+			for _, s := range d.Sessions() {
+				volume, mute, err := currentState(s)
+				if err != nil {
+					continue
+				}
+				state.Sessions = append(state.Sessions, SessionState{
+					ProcessID:   s.Pid(),
+					Executable:  s.Executable(),
+					Path:        s.Path(),
+					DisplayName: s.DisplayName(),
+					System:      s.IsSystem(),
+					Volume:      volume,
+					Mute:        mute,
+				})
+			}
+			states = append(states, state)
+		}
+	})
+	return states
+}
+
+// SetTargetState sets the targets of m to exactly volume and mute, either can be nil to leave it alone. Only the
+// targets of the mapping are used, its trigger & action don't matter. The changes are queued up along with the ones
+// from MIDI messages, so they happen in order and new audio sessions pick up the volume the same way.
+func (r *Router) SetTargetState(m *mixer.Mapping, volume *float32, mute *bool) error {
+	keys := mappingTargetKeys(m)
+	if len(keys) == 0 {
+		return ErrorNoTargets
+	}
+
+	if volume != nil {
+		u := update{mapping: *m, volumeLevel: *volume}
+		u.mapping.Action = mixer.ActionVolume
+		u.mapping.Encoder = ""
+		u.mapping.Takeover = ""
+		for _, key := range keys {
+			r.enqueueUpdate(key, u)
+		}
+	}
+	if mute != nil {
+		// setMute mutes while the value is at or above the threshold, like a latching button.
+		u := update{mapping: *m}
+		u.mapping.Action = mixer.ActionSetMute
+		u.mapping.Threshold = 1
+		if *mute {
+			u.value = 1
+		}
+		for _, key := range keys {
+			r.enqueueUpdate(key, u)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package audio
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/GregoryDosh/automidically/internal/mixer"
+	"github.com/GregoryDosh/automidically/internal/pattern"
+)
+
+// The kinds of targets a mapping can have, these line up with the parameters of a mixer mapping.
+const (
+	targetFilename    = "filename"
+	targetPath        = "path"
+	targetTitle       = "title"
+	targetDisplayName = "displayName"
+	targetSpecial     = "special"
+	targetDevice      = "device"
+	// targetDefaultDevice is for the defaultDevice action, which switches between the devices of the mapping.
+	targetDefaultDevice = "defaultDevice"
+	// targetToggleMute is for the toggleMute action, which looks at all of the targets of the mapping together.
+	targetToggleMute = "toggleMute"
+)
+
+// targetKey names a single target of a mapping, like one filename or special, so updates to it can be ordered.
+type targetKey struct {
+	kind string
+	name string
+	// processTree also matches audio sessions of child processes of a matching process.
+	processTree bool
+	// sessionDevice limits the audio sessions to the devices matching this, or the default device.
+	// Empty means the audio sessions of every device.
+	sessionDevice string
+	// flow is whether the audio sessions are on output devices, input devices, or any. Empty is output.
+	flow string
+	// mapping is the ID of the mapping for targetToggleMute, since all of its targets are toggled together.
+	mapping string
+}
+
+// isSession is true for the targets that are audio sessions, which can come and go while Automidically is running.
+// The active window is left out since the session it refers to changes all of the time.
+func (key targetKey) isSession() bool {
+	switch key.kind {
+	case targetFilename, targetPath, targetTitle, targetDisplayName:
+		return true
+	case targetSpecial:
+		return strings.EqualFold(key.name, "system")
+	}
+	return false
+}
+
+// newTargetKeys makes a key of the kind for each of the names, the rest of the key is copied from options.
+func newTargetKeys(kind string, names []string, options targetKey) []targetKey {
+	keys := []targetKey{}
+	for _, n := range names {
+		key := options
+		key.kind = kind
+		key.name = n
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// mappingTargetKeys lists all of the targets of a mapping, leaving out the specials that are actions.
+func mappingTargetKeys(m *mixer.Mapping) []targetKey {
+	specials := []string{}
+	for _, s := range m.Special {
+		if strings.EqualFold(s, "refreshDevices") || strings.EqualFold(s, "refreshSessions") {
+			continue
+		}
+		specials = append(specials, s)
+	}
+	flow := strings.ToLower(m.Flow)
+	tree := targetKey{processTree: m.ProcessTree, sessionDevice: m.SessionDevice, flow: flow}
+	options := targetKey{sessionDevice: m.SessionDevice, flow: flow}
+	keys := newTargetKeys(targetFilename, m.Filename, tree)
+	keys = append(keys, newTargetKeys(targetPath, m.Path, tree)...)
+	keys = append(keys, newTargetKeys(targetTitle, m.Title, tree)...)
+	keys = append(keys, newTargetKeys(targetDisplayName, m.DisplayName, options)...)
+	keys = append(keys, newTargetKeys(targetSpecial, specials, options)...)
+	keys = append(keys, newTargetKeys(targetDevice, m.Device, targetKey{})...)
+	return keys
+}
+
+// sessionMatcher builds the function deciding if an audio session belongs to a target. With processTree the
+// session also matches if one of the ancestors of its process does. The names are looked up in patterns, which
+// can be nil.
+func sessionMatcher(key targetKey, patterns pattern.Set) func(Session) bool {
+	var matchProcess func(p Process) bool
+	switch key.kind {
+	case targetFilename:
+		matchProcess = func(p Process) bool {
+			return patterns.Match(key.name, p.Executable())
+		}
+	case targetPath:
+		matchProcess = func(p Process) bool {
+			return patterns.Match(key.name, p.Path())
+		}
+	case targetTitle:
+		matchProcess = func(p Process) bool {
+			for _, title := range p.WindowTitles() {
+				if patterns.Match(key.name, title) {
+					return true
+				}
+			}
+			return false
+		}
+	case targetDisplayName:
+		return func(s Session) bool {
+			return patterns.Match(key.name, s.DisplayName())
+		}
+	case targetSpecial:
+		if strings.EqualFold(key.name, "system") {
+			return func(s Session) bool {
+				return s.IsSystem()
+			}
+		}
+		return func(s Session) bool {
+			return false
+		}
+	default:
+		return func(s Session) bool {
+			return false
+		}
+	}
+
+	return func(s Session) bool {
+		if matchProcess(s) {
+			return true
+		}
+		if key.processTree {
+			for _, p := range s.Ancestors() {
+				if matchProcess(p) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// forEachTarget calls fn with every device or audio session of the devices referred to by the target keys.
+// Names can be patterns, see the pattern package, the compiled ones are taken from patterns if they're there.
+// This is expected to be called from within a Backend.View.
+func (r *Router) forEachTarget(devices []Device, keys []targetKey, patterns pattern.Set, fn func(Control) error) {
+	sessions := func(key targetKey, match func(Session) bool) error {
+		found := false
+		for _, d := range sessionDevices(devices, key, patterns) {
+			for _, s := range d.Sessions() {
+				if !match(s) {
+					continue
+				}
+				if err := fn(s); err != nil {
+					if !errors.Is(err, ErrorSessionExpired) {
+						log.Error(err)
+					}
+					continue
+				}
+				found = true
+			}
+		}
+		if !found {
+			return ErrorSessionNotFound
+		}
+		return nil
+	}
+	endpoint := func(flow Flow, role Role) {
+		for _, d := range devices {
+			if d.Flow() != flow || !d.IsDefault(role) {
+				continue
+			}
+			if err := fn(d); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+	}
+
+	for _, key := range keys {
+		switch key.kind {
+		case targetSpecial:
+			switch strings.ToLower(key.name) {
+			case "active":
+				r.forEachActiveSession(func(match func(Session) bool) error {
+					return sessions(key, match)
+				})
+			case "system":
+				_ = sessions(key, sessionMatcher(key, patterns))
+			case "output":
+				endpoint(Output, Console)
+			case "input":
+				endpoint(Input, Console)
+			case "communicationsoutput":
+				endpoint(Output, Communications)
+			case "communicationsinput":
+				endpoint(Input, Communications)
+			}
+		case targetDevice:
+			for _, d := range devices {
+				if patterns.Match(key.name, d.Name()) {
+					if err := fn(d); err != nil {
+						log.Error(err)
+					}
+				}
+			}
+		default:
+			_ = sessions(key, sessionMatcher(key, patterns))
+		}
+	}
+}
+
+// sessionDevices returns the devices whose audio sessions can be used for the target, going by its flow & sessionDevice.
+func sessionDevices(devices []Device, key targetKey, patterns pattern.Set) []Device {
+	matching := []Device{}
+	for _, d := range devices {
+		if sessionDeviceMatches(key, d, patterns) {
+			matching = append(matching, d)
+		}
+	}
+	return matching
+}
+
+// sessionDeviceMatches checks a device against the flow and sessionDevice of a target, "default" is the default
+// device of the flow.
+func sessionDeviceMatches(key targetKey, d Device, patterns pattern.Set) bool {
+	switch key.flow {
+	case "", strings.ToLower(mixer.FlowOutput):
+		if d.Flow() != Output {
+			return false
+		}
+	case strings.ToLower(mixer.FlowInput):
+		if d.Flow() != Input {
+			return false
+		}
+	}
+	if key.sessionDevice == "" {
+		return true
+	}
+	if strings.EqualFold(key.sessionDevice, "default") {
+		return d.IsDefault(Console)
+	}
+	return patterns.Match(key.sessionDevice, d.Name())
+}
+
+// forEachActiveSession finds the audio sessions of the active window. Browsers and the like often play audio from
+// a child process, so if the active process doesn't have a session then its descendants are used instead.
+func (r *Router) forEachActiveSession(sessions func(func(Session) bool) error) {
+	if r.active == nil {
+		return
+	}
+	activeFilename := r.active.ProcessFilename()
+	activeID := r.active.ProcessID()
+	if activeFilename == "" {
+		return
+	}
+	err := sessions(func(s Session) bool {
+		return strings.EqualFold(s.Executable(), activeFilename)
+	})
+	if !errors.Is(err, ErrorSessionNotFound) {
+		return
+	}
+	_ = sessions(func(s Session) bool {
+		for _, p := range s.Ancestors() {
+			if p.Pid() == activeID {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// GetTargetState returns the volume and mute state of the first device or audio session found for
+// the filenames, specials, and device names. ok is false if none of them currently exist. The patterns can be nil,
+// they're compiled as needed then.
+func (r *Router) GetTargetState(filenames, specials, devices []string, patterns pattern.Set) (volume float32, mute bool, ok bool) {
+	keys := newTargetKeys(targetFilename, filenames, targetKey{})
+	keys = append(keys, newTargetKeys(targetSpecial, specials, targetKey{})...)
+	keys = append(keys, newTargetKeys(targetDevice, devices, targetKey{})...)
+	r.backend.View(func(all []Device) {
+		r.forEachTarget(all, keys, patterns, func(c Control) error {
+			if ok {
+				return nil
+			}
+			v, m, err := currentState(c)
+			if err != nil {
+				return err
+			}
+			volume, mute, ok = v, m, true
+			return nil
+		})
+	})
+	return volume, mute, ok
+}
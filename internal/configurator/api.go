@@ -0,0 +1,365 @@
+package configurator
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+	"github.com/GregoryDosh/automidically/internal/mixer"
+	"github.com/GregoryDosh/automidically/internal/systray"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrorAPINotLoopback  = errors.New("api address should be on the loopback interface")
+	ErrorAPIMissingToken = errors.New("api token is required")
+	ErrorAPIUnauthorized = errors.New("missing or wrong api token")
+	ErrorAPINoAudio      = errors.New("audio isn't available")
+	ErrorAPIShuttingDown = errors.New("shutting down")
+)
+
+// defaultAPIAddress is where the API listens unless the config says otherwise.
+const defaultAPIAddress = "127.0.0.1:7007"
+
+// apiMaxBodySize is plenty for a mapping, anything bigger is turned away.
+const apiMaxBodySize = 64 * 1024
+
+// apiOK is the reply to requests that don't have anything else to say.
+var apiOK = map[string]bool{"ok": true}
+
+// APIOptions is the local HTTP/JSON API for controlling Automidically from other programs. It's off by default,
+// and only listens on the loopback interface so it can't be reached from other computers.
+type APIOptions struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Token   string `yaml:"token"`
+}
+
+func (o *APIOptions) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// This is so we can set some default values if not specified in the config.
+	type rawOptions APIOptions
+	raw := rawOptions{
+		Address: defaultAPIAddress,
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	*o = APIOptions(raw)
+	return nil
+}
+
+func (o *APIOptions) Validate() error {
+	if !o.Enabled {
+		return nil
+	}
+	if o.Token == "" {
+		return ErrorAPIMissingToken
+	}
+	host, _, err := net.SplitHostPort(o.Address)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(host, "localhost") {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrorAPINotLoopback, o.Address)
+}
+
+// apiError is an error along with the HTTP status to reply with.
+type apiError struct {
+	status int
+	err    error
+}
+
+func (e *apiError) Error() string {
+	return e.err.Error()
+}
+
+func badRequest(err error) error {
+	return &apiError{status: http.StatusBadRequest, err: err}
+}
+
+// apiHandler handles a request, the result is sent back as JSON.
+type apiHandler func(r *http.Request) (interface{}, error)
+
+// apiAccepted is the result of a handler that only started something, it's sent back as a 202 instead of a 200.
+type apiAccepted struct {
+	body interface{}
+}
+
+// updateAPI starts, stops, or restarts the API to match the options.
+// This expects the configurator to be locked already.
+func (c *Configurator) updateAPI(options APIOptions) {
+	c.stopAPI()
+	c.API = options
+	if !options.Enabled {
+		return
+	}
+
+	l, err := net.Listen("tcp", options.Address)
+	if err != nil {
+		log.Errorf("unable to start the api: %s", err)
+		return
+	}
+	c.apiServer = &http.Server{
+		Handler:           c.newAPIMux(options.Token),
+		ReadHeaderTimeout: time.Second * 5,
+	}
+	go func(s *http.Server) {
+		if err := s.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(err)
+		}
+	}(c.apiServer)
+	log.Infof("api listening on http://%s", l.Addr())
+}
+
+// stopAPI closes the API right away. Requests that are in the middle of being handled are cut off instead of
+// waited on, since they might be waiting on the configurator's lock.
+// This expects the configurator to be locked already.
+func (c *Configurator) stopAPI() {
+	if c.apiServer == nil {
+		return
+	}
+	if err := c.apiServer.Close(); err != nil {
+		log.Error(err)
+	}
+	c.apiServer = nil
+}
+
+func (c *Configurator) newAPIMux(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/midi/devices", apiRoute(http.MethodGet, c.apiMIDIDevices))
+	mux.Handle("/midi/trigger", apiRoute(http.MethodPost, c.apiTrigger))
+	mux.Handle("/audio/devices", apiRoute(http.MethodGet, c.apiAudioDevices))
+	mux.Handle("/audio/target", apiRoute(http.MethodPost, c.apiSetTarget))
+	mux.Handle("/reload/config", apiRoute(http.MethodPost, c.apiReloadConfig))
+	mux.Handle("/reload/devices", apiRoute(http.MethodPost, c.apiSystrayMessage(systray.SystrayRefreshDevices)))
+	mux.Handle("/reload/sessions", apiRoute(http.MethodPost, c.apiSystrayMessage(systray.SystrayRefreshSessions)))
+	mux.Handle("/events", apiRoute(http.MethodGet, c.apiEvents))
+	return apiAuth(token, c.apiRunning(mux))
+}
+
+// apiRunning turns away requests once shutting down. Closing the server doesn't wait for the requests that already
+// came in, and the things they'd use are being closed.
+func (c *Configurator) apiRunning(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Lock()
+		shuttingDown := c.shuttingDown
+		c.Unlock()
+		if shuttingDown {
+			writeAPIResponse(w, http.StatusServiceUnavailable, map[string]string{"error": ErrorAPIShuttingDown.Error()})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiAuth turns away any request without the token, it's expected as "Authorization: Bearer <token>".
+func apiAuth(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeAPIResponse(w, http.StatusUnauthorized, map[string]string{"error": ErrorAPIUnauthorized.Error()})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiRoute only lets the method through to the handler, and writes whatever it returns as JSON.
+func apiRoute(method string, handler apiHandler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Debugf("api %s %s", r.Method, r.URL.Path)
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeAPIResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": fmt.Sprintf("use %s", method)})
+			return
+		}
+
+		result, err := handler(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var e *apiError
+			if errors.As(err, &e) {
+				status = e.status
+			}
+			writeAPIResponse(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		if a, ok := result.(apiAccepted); ok {
+			writeAPIResponse(w, http.StatusAccepted, a.body)
+			return
+		}
+		writeAPIResponse(w, http.StatusOK, result)
+	})
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Debug(err)
+	}
+}
+
+// readAPIBody turns the JSON body into YAML, so it can be read into the same types as the config with all of
+// their defaults and ways of writing things. JSON numbers come out as floats, which YAML is happy to read as ints.
+func readAPIBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, apiMaxBodySize))
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, badRequest(err)
+	}
+	if _, ok := v.(map[string]interface{}); !ok {
+		return nil, badRequest(errors.New("body should be a JSON object"))
+	}
+	y, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	return y, nil
+}
+
+type midiDeviceState struct {
+	Name      string `json:"name"`
+	Search    string `json:"search"`
+	Connected bool   `json:"connected"`
+}
+
+// apiMIDIDevices lists the configured MIDI devices and if they're connected.
+func (c *Configurator) apiMIDIDevices(r *http.Request) (interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+	devices := []midiDeviceState{}
+	for name, d := range c.MIDIDevices {
+		devices = append(devices, midiDeviceState{
+			Name:      name,
+			Search:    c.MIDIDeviceNames[name],
+			Connected: d != nil && d.Connected(),
+		})
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Name < devices[j].Name })
+	return devices, nil
+}
+
+// apiTrigger handles a MIDI message as if it came from a device. The body is a trigger like the mappings have,
+// along with the value, e.g. {"cc": 7, "value": 64}.
+func (c *Configurator) apiTrigger(r *http.Request) (interface{}, error) {
+	body, err := readAPIBody(r)
+	if err != nil {
+		return nil, err
+	}
+	t := message.DefaultTrigger()
+	if err := yaml.Unmarshal(body, &t); err != nil {
+		return nil, badRequest(err)
+	}
+	v := struct {
+		Value *int `yaml:"value"`
+	}{}
+	if err := yaml.Unmarshal(body, &v); err != nil {
+		return nil, badRequest(err)
+	}
+	if err := t.Validate(); err != nil {
+		return nil, badRequest(err)
+	}
+	if v.Value == nil || *v.Value < 0 || *v.Value > t.MaxValue() {
+		return nil, badRequest(fmt.Errorf("value should be in range [0,%d]", t.MaxValue()))
+	}
+
+	msg := t.Message(*v.Value)
+	msg.Device = t.MIDIDevice
+	msg.Timestamp = time.Now()
+	c.midiMessageCallback(msg)
+	return apiOK, nil
+}
+
+// apiAudioDevices lists the audio devices & sessions with their volumes.
+func (c *Configurator) apiAudioDevices(r *http.Request) (interface{}, error) {
+	if c.audio == nil {
+		return nil, &apiError{status: http.StatusServiceUnavailable, err: ErrorAPINoAudio}
+	}
+	return c.audio.Devices(), nil
+}
+
+// apiSetTarget sets the volume and/or mute of the targets. The body has the targets like a mixer mapping,
+// along with the volume in [0,1] and mute, e.g. {"filename": "game.exe", "volume": 0.5}.
+func (c *Configurator) apiSetTarget(r *http.Request) (interface{}, error) {
+	if c.audio == nil {
+		return nil, &apiError{status: http.StatusServiceUnavailable, err: ErrorAPINoAudio}
+	}
+	body, err := readAPIBody(r)
+	if err != nil {
+		return nil, err
+	}
+	var m mixer.Mapping
+	if err := yaml.Unmarshal(body, &m); err != nil {
+		return nil, badRequest(err)
+	}
+	state := struct {
+		Volume *float32 `yaml:"volume"`
+		Mute   *bool    `yaml:"mute"`
+	}{}
+	if err := yaml.Unmarshal(body, &state); err != nil {
+		return nil, badRequest(err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, badRequest(err)
+	}
+	m.CompilePatterns()
+	if state.Volume == nil && state.Mute == nil {
+		return nil, badRequest(errors.New("volume or mute is required"))
+	}
+	if state.Volume != nil && (*state.Volume < 0 || *state.Volume > 1) {
+		return nil, badRequest(fmt.Errorf("volume %f should be in range [0,1]", *state.Volume))
+	}
+
+	if err := c.audio.SetTargetState(&m, state.Volume, state.Mute); err != nil {
+		if errors.Is(err, audio.ErrorNoTargets) {
+			return nil, badRequest(err)
+		}
+		return nil, err
+	}
+	return apiOK, nil
+}
+
+// apiSystrayMessage does the same thing as the menu item in the systray.
+func (c *Configurator) apiSystrayMessage(msg systray.Message) apiHandler {
+	return func(r *http.Request) (interface{}, error) {
+		c.HandleSystrayMessage(msg)
+		return apiOK, nil
+	}
+}
+
+// apiReloadConfig queues a reload of the config. It happens in the background, the config event says when it's done.
+func (c *Configurator) apiReloadConfig(r *http.Request) (interface{}, error) {
+	c.ReloadConfig()
+	return apiAccepted{apiOK}, nil
+}
+
+// apiEvents returns the latest events, or only the ones after the since query parameter.
+func (c *Configurator) apiEvents(r *http.Request) (interface{}, error) {
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return nil, badRequest(fmt.Errorf("since should be an event id: %w", err))
+		}
+	}
+	return c.events.since(since), nil
+}
@@ -0,0 +1,56 @@
+package configurator
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIReloadConfig(t *testing.T) {
+	tc := newTestConfigurator(t, testConfig)
+	mux := tc.newAPIMux("secret")
+
+	post := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/reload/config", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Nothing is reading the reloads here, so only the first one is queued & the rest can't block.
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			if code := post(); code != http.StatusAccepted {
+				t.Errorf("reload %d returned %d, expected %d", i, code, http.StatusAccepted)
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("reloading the config blocked")
+	}
+	if len(tc.reloadConfig) != 1 {
+		t.Errorf("expected 1 reload to be queued, found %d", len(tc.reloadConfig))
+	}
+}
+
+func TestAPIShuttingDown(t *testing.T) {
+	tc := newTestConfigurator(t, testConfig)
+	mux := tc.newAPIMux("secret")
+	tc.Quit()
+
+	for _, path := range []string{"/reload/config", "/reload/devices", "/reload/sessions"} {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s returned %d after shutting down, expected %d", path, w.Code, http.StatusServiceUnavailable)
+		}
+	}
+}
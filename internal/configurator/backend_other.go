@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package configurator
+
+import (
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/pulseaudio"
+)
+
+// newAudioBackend uses PulseAudio, or PipeWire through pipewire-pulse. There isn't a foreground window to
+// follow, so the activeWindow special doesn't match anything.
+func newAudioBackend() (audio.Backend, audio.ActiveWindow, error) {
+	pa, err := pulseaudio.New()
+	if err != nil {
+		return nil, nil, err
+	}
+	return pa, nil, nil
+}
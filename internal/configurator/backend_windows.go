@@ -0,0 +1,16 @@
+package configurator
+
+import (
+	"github.com/GregoryDosh/automidically/internal/activewindow"
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/coreaudio"
+)
+
+// newAudioBackend uses the Windows Core Audio APIs, along with the foreground window for the activeWindow special.
+func newAudioBackend() (audio.Backend, audio.ActiveWindow, error) {
+	ca, err := coreaudio.New()
+	if err != nil {
+		return nil, nil, err
+	}
+	return ca, activewindow.GetListener(), nil
+}
@@ -2,14 +2,18 @@ package configurator
 
 import (
 	"io/ioutil"
+	"net/http"
 	"reflect"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/GregoryDosh/automidically/internal/coreaudio"
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/feedback"
 	"github.com/GregoryDosh/automidically/internal/midi"
+	"github.com/GregoryDosh/automidically/internal/midi/message"
 	"github.com/GregoryDosh/automidically/internal/mixer"
+	"github.com/GregoryDosh/automidically/internal/pattern"
 	"github.com/GregoryDosh/automidically/internal/shell"
 	"github.com/GregoryDosh/automidically/internal/systray"
 	"github.com/bep/debounce"
@@ -21,18 +25,50 @@ import (
 var log = logrus.WithField("module", "configurator")
 
 type MappingOptions struct {
-	Mixer []mixer.Mapping `yaml:"mixer,omitempty"`
-	Shell []shell.Mapping `yaml:"shell,omitempty"`
+	Mixer    []mixer.Mapping    `yaml:"mixer,omitempty"`
+	Shell    []shell.Mapping    `yaml:"shell,omitempty"`
+	Feedback []feedback.Mapping `yaml:"feedback,omitempty"`
+}
+
+// Validate checks all of the mappings, returning the first error found.
+func (o *MappingOptions) Validate() error {
+	for i := range o.Mixer {
+		if err := o.Mixer[i].Validate(); err != nil {
+			return err
+		}
+	}
+	for i := range o.Shell {
+		if err := o.Shell[i].Validate(); err != nil {
+			return err
+		}
+	}
+	for i := range o.Feedback {
+		if err := o.Feedback[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type Configurator struct {
-	filename       string
-	EchoMIDIEvents bool           `yaml:"echoMIDIEvents"`
-	Mapping        MappingOptions `yaml:"mapping,omitempty"`
-	MIDIDevice     *midi.Device
-	MIDIDeviceName string `yaml:"midiDevicename"`
-	coreAudio      *coreaudio.CoreAudio
-	reloadConfig   chan bool
+	filename        string
+	EchoMIDIEvents  bool           `yaml:"echoMIDIEvents"`
+	Mapping         MappingOptions `yaml:"mapping,omitempty"`
+	MIDIDevices     map[string]*midi.Device
+	MIDIDeviceNames MIDIDeviceNames `yaml:"midiDevicename"`
+	API             APIOptions      `yaml:"api"`
+	audio           *audio.Router
+	apiServer       *http.Server
+	events          eventLog
+	reloadConfig    chan bool
+	feedbackNow     chan bool
+	highResolution  *message.HighResolutionTracker
+	routes          atomic.Value
+	// feedbackPatterns are the compiled patterns of each of the feedback mappings, in the same order.
+	feedbackPatterns []pattern.Set
+	// feedbackGeneration is bumped on each reload so the feedbackLoop knows to resend everything.
+	feedbackGeneration int
+	shuttingDown       bool
 	sync.Mutex
 }
 
@@ -61,8 +97,9 @@ func (c *Configurator) updateConfigFromDiskLoop() {
 			if !ok {
 				return
 			}
+			// Straight to the debounce, this loop is the only one reading reloadConfig so it can't send to it.
 			if event.Op&fsnotify.Write == fsnotify.Write {
-				c.reloadConfig <- true
+				d(c.readConfigFromDiskAndInit)
 			}
 		case err, ok := <-fileWatcher.Errors:
 			if !ok {
@@ -88,44 +125,41 @@ func (c *Configurator) readConfigFromDiskAndInit() {
 	// without locking and so that we don't lock or cleanup unnessarily
 	// if it's not needed since we could have a bad config.
 	newMapping := struct {
-		Mapping        MappingOptions `yaml:"mapping"`
-		MIDIDeviceName string         `yaml:"midiDevicename"`
-		EchoMIDIEvents bool           `yaml:"echoMIDIEvents"`
+		Mapping         MappingOptions  `yaml:"mapping"`
+		MIDIDeviceNames MIDIDeviceNames `yaml:"midiDevicename"`
+		EchoMIDIEvents  bool            `yaml:"echoMIDIEvents"`
+		API             APIOptions      `yaml:"api"`
 	}{}
 	if err := yaml.Unmarshal(f, &newMapping); err != nil {
 		log.Errorf("unable to parse new config: %s", err)
 		return
 	}
 
+	// Everything is validated before any of it is used, so a mistake in one section doesn't leave the others
+	// half applied.
+	if err := newMapping.Mapping.Validate(); err != nil {
+		log.Errorf("unable to parse new config: %s", err)
+		return
+	}
+
 	// Lock the configuration, do cleanup on the soon to be replaced configs
 	// then replace the old with the new and call any initialization routines.
 	c.Lock()
 	defer c.Unlock()
 
-	// Midi Device Cleanup & Initialiation
-	if !strings.EqualFold(newMapping.MIDIDeviceName, c.MIDIDeviceName) {
-		if c.MIDIDeviceName != "" {
-			log.Trace("MIDI device name changed")
-		}
-		if c.MIDIDevice != nil {
-			if err := c.MIDIDevice.Cleanup(); err != nil {
-				log.Error(err)
-			}
-		}
-		c.MIDIDeviceName = newMapping.MIDIDeviceName
-		c.MIDIDevice = midi.New(c.MIDIDeviceName)
+	// The MIDI devices were already closed, so don't open them again.
+	if c.shuttingDown {
+		return
 	}
 
+	// Midi Device Cleanup & Initialiation
+	c.updateMIDIDevices(newMapping.MIDIDeviceNames)
+
 	mappingChanged := false
 
 	// Mixer
-	for _, mapping := range newMapping.Mapping.Mixer {
-		if err := mapping.Validate(); err != nil {
-			log.Errorf("unable to parse new config: %s", err)
-			return
-		}
-	}
-	if !reflect.DeepEqual(c.Mapping.Mixer, newMapping.Mapping.Mixer) {
+	mixerChanged := !reflect.DeepEqual(c.Mapping.Mixer, newMapping.Mapping.Mixer)
+	if mixerChanged {
 		mappingChanged = true
 		log.Debug("detected new mixer mappings")
 		c.Mapping.Mixer = newMapping.Mapping.Mixer
@@ -138,82 +172,172 @@ func (c *Configurator) readConfigFromDiskAndInit() {
 		c.Mapping.Shell = newMapping.Mapping.Shell
 	}
 
-	if c.MIDIDevice != nil {
-		c.MIDIDevice.SetMessageCallback(c.midiMessageCallback)
+	// Feedback
+	if !reflect.DeepEqual(c.Mapping.Feedback, newMapping.Mapping.Feedback) {
+		mappingChanged = true
+		log.Debug("detected new feedback mappings")
+		c.Mapping.Feedback = newMapping.Mapping.Feedback
+		c.feedbackPatterns = make([]pattern.Set, len(c.Mapping.Feedback))
+		for i, m := range c.Mapping.Feedback {
+			c.feedbackPatterns[i] = pattern.NewSet(m.Filename, m.Device)
+		}
 	}
+	// Resend all feedback after a reload in case new devices were added.
+	c.feedbackGeneration++
+
+	for _, d := range c.MIDIDevices {
+		if d != nil {
+			d.SetMessageCallback(c.midiMessageCallback)
+		}
+	}
+
+	c.warnUnknownMIDIDevices()
 
 	// EchoMIDIEvents
 	c.EchoMIDIEvents = newMapping.EchoMIDIEvents
 
+	// Swapping in the new routes last so the MIDI callback only ever sees a complete config, the audio gets the
+	// mixer mappings at the same time so the remembered volumes line up with the routes.
+	routes := newRoutingTable(c.Mapping, c.EchoMIDIEvents)
+	if mixerChanged && c.audio != nil {
+		c.audio.SetMixerMappings(routes.mixers)
+	}
+	c.routes.Store(routes)
+
+	// API, a mistake here leaves the API as it was instead of holding up the mappings.
+	if err := newMapping.API.Validate(); err != nil {
+		log.Errorf("unable to parse new api config: %s", err)
+	} else if !reflect.DeepEqual(c.API, newMapping.API) {
+		log.Debug("detected new api config")
+		c.updateAPI(newMapping.API)
+	}
+
+	c.events.add(event{Type: eventConfig})
 	log.Debug("completed configuration reload")
 	if mappingChanged {
 		log.Tracef("%+v", c.Mapping)
 	}
 }
 
-func (c *Configurator) midiMessageCallback(cc int, v int) {
-	c.Lock()
-	defer c.Unlock()
-	if c.EchoMIDIEvents {
+// midiMessageCallback is called for every message from the MIDI devices. It only reads the routing table
+// so a config reload never holds up the messages.
+func (c *Configurator) midiMessageCallback(msg message.Message) {
+	routes, ok := c.routes.Load().(*routingTable)
+	if !ok {
+		return
+	}
+	c.events.addMIDIMessage(msg)
+	if routes.echoMIDIEvents {
 		log.WithFields(logrus.Fields{
-			"CC":    cc,
-			"Value": v,
+			"Type":    msg.Kind,
+			"Channel": msg.Channel,
+			"Number":  msg.Number,
+			"Value":   msg.Value,
+			"Device":  msg.Device,
 		}).Info()
 	}
-	for _, m := range c.Mapping.Mixer {
-		go func(m mixer.Mapping) {
-			c.coreAudio.HandleMIDIMessage(&m, cc, v)
-		}(m)
+	// A message might be half of a 14-bit pair, in which case the combined message is handled too.
+	msgs := []message.Message{msg}
+	if combined, ok := c.highResolution.Track(msg); ok {
+		msgs = append(msgs, combined)
 	}
-	for _, m := range c.Mapping.Shell {
-		go func(m shell.Mapping) {
-			m.HandleMIDIMessage(cc, v)
-		}(m)
+	for _, msg := range msgs {
+		// The mixer queues up changes per target so these are handled in order, without blocking.
+		for _, m := range routes.mixerMappings(msg) {
+			if c.audio != nil {
+				c.audio.HandleMIDIMessage(m, msg)
+			}
+		}
+		for _, m := range routes.shellMappings(msg) {
+			if !m.Matches(msg) {
+				continue
+			}
+			go m.HandleMIDIMessage(msg)
+		}
+	}
+}
+
+// ReloadConfig reads the config from disk again, the same as the Reload > Config menu item. The reload happens in
+// the background, and if one is already waiting to happen this doesn't add another.
+func (c *Configurator) ReloadConfig() {
+	select {
+	case c.reloadConfig <- true:
+	default:
+	}
+}
+
+// Quit closes everything down, the same as the Quit menu item.
+func (c *Configurator) Quit() {
+	// The API & MIDI devices are closed first so nothing new comes in while the audio is being cleaned up.
+	log.Trace("Starting cleanup & shutdown procedures.")
+	c.Lock()
+	if c.shuttingDown {
+		c.Unlock()
+		return
+	}
+	c.shuttingDown = true
+	c.stopAPI()
+	c.cleanupMIDIDevices()
+	c.Unlock()
+	if c.audio != nil {
+		if err := c.audio.Cleanup(); err != nil {
+			log.Error(err)
+		}
 	}
 }
 
 func (c *Configurator) HandleSystrayMessage(msg systray.Message) {
 	if msg == systray.SystrayRefreshConfig {
-		c.reloadConfig <- true
+		c.ReloadConfig()
 		return
 	}
 	if msg == systray.SystrayQuit {
-		log.Trace("Starting cleanup & shutdown procedures.")
-		if c.MIDIDevice != nil {
-			if err := c.MIDIDevice.Cleanup(); err != nil {
-				log.Error(err)
-			}
-		}
-		if err := c.coreAudio.Cleanup(); err != nil {
-			log.Error(err)
-		}
+		c.Quit()
 		return
 	}
+	// The audio is closed once shutting down, a late click or API request can't refresh it anymore.
 	go func() {
 		c.Lock()
 		defer c.Unlock()
-		c.coreAudio.HandleSystrayMessage(msg)
+		if c.audio == nil || c.shuttingDown {
+			return
+		}
+		switch msg {
+		case systray.SystrayRefreshDevices:
+			c.audio.RefreshDevices()
+		case systray.SystrayRefreshSessions:
+			c.audio.RefreshSessions()
+		}
 	}()
 	go func() {
 		c.Lock()
 		defer c.Unlock()
+		if c.shuttingDown {
+			return
+		}
 		shell.HandleSystrayMessage(msg)
 	}()
 }
 
 func New(filename string) *Configurator {
-	ca, err := coreaudio.New()
-	if err != nil {
-		log.Error(err)
+	c := &Configurator{
+		filename:       filename,
+		reloadConfig:   make(chan bool, 1),
+		feedbackNow:    make(chan bool, 1),
+		highResolution: message.NewHighResolutionTracker(),
 	}
 
-	c := &Configurator{
-		filename:     filename,
-		reloadConfig: make(chan bool, 1),
-		coreAudio:    ca,
+	backend, active, err := newAudioBackend()
+	if err != nil {
+		log.Error(err)
+	} else {
+		c.audio = audio.New(backend, active)
+		c.audio.Subscribe(c.onVolumeChanged)
+		c.audio.Subscribe(c.events.addVolumeChange)
 	}
 
 	go c.updateConfigFromDiskLoop()
+	go c.feedbackLoop()
 	c.reloadConfig <- true
 
 	return c
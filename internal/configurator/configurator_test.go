@@ -0,0 +1,320 @@
+package configurator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+)
+
+const testConfig = `
+echoMIDIEvents: true
+mapping:
+  mixer:
+    - cc: 1
+      filename: glob:game*.exe
+    - cc: 2
+      special: output
+      volumeMin: 0.2
+      volumeMax: 0.8
+    - type: note
+      note: 60
+      action: toggleMute
+      filename: [game.exe, chat.exe]
+    - cc: 3
+      highResolution: true
+      device: Headphones
+  shell:
+    - type: programChange
+      program: 99
+      channel: 16
+      command: echo
+  feedback:
+    - cc: 1
+      filename: game.exe
+    - cc: 2
+      special: output
+      volumeMin: 0.2
+      volumeMax: 0.8
+    - note: 60
+      type: note
+      property: mute
+      filename: game.exe
+      onValue: 100
+      offValue: 10
+`
+
+type testConfigurator struct {
+	*Configurator
+	fake       *audio.Fake
+	speakers   *audio.FakeDevice
+	headphones *audio.FakeDevice
+	game       *audio.FakeSession
+	chat       *audio.FakeSession
+}
+
+// newTestConfigurator loads the config with a Fake backend, leaving out the file watching & feedback loops.
+func newTestConfigurator(t *testing.T, config string) *testConfigurator {
+	t.Helper()
+	tc := &testConfigurator{fake: audio.NewFake()}
+	tc.speakers = tc.fake.AddDevice("1", "Speakers", audio.Output)
+	tc.headphones = tc.fake.AddDevice("2", "Headphones", audio.Output)
+	tc.game = tc.speakers.AddSession(audio.FakeProcess{ProcessID: 10, ProcessExecutable: "game.exe"})
+	tc.chat = tc.speakers.AddSession(audio.FakeProcess{ProcessID: 20, ProcessExecutable: "chat.exe"})
+
+	tc.Configurator = &Configurator{
+		filename:       filepath.Join(t.TempDir(), "config.yml"),
+		reloadConfig:   make(chan bool, 1),
+		feedbackNow:    make(chan bool, 1),
+		highResolution: message.NewHighResolutionTracker(),
+		audio:          audio.New(tc.fake, nil),
+	}
+	tc.audio.Subscribe(tc.onVolumeChanged)
+	tc.audio.Subscribe(tc.events.addVolumeChange)
+	t.Cleanup(func() {
+		tc.Quit()
+	})
+	tc.load(t, config)
+	return tc
+}
+
+func (tc *testConfigurator) load(t *testing.T, config string) {
+	t.Helper()
+	if err := ioutil.WriteFile(tc.filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tc.readConfigFromDiskAndInit()
+}
+
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 2)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func volumeIs(c audio.Control, expect float32) func() bool {
+	return func() bool {
+		v, err := c.GetVolumeLevel()
+		return err == nil && math.Abs(float64(v-expect)) < 0.001
+	}
+}
+
+func muteIs(c audio.Control, expect bool) func() bool {
+	return func() bool {
+		m, err := c.GetMute()
+		return err == nil && m == expect
+	}
+}
+
+func cc(number, value int) message.Message {
+	return message.Message{Kind: message.ControlChange, Channel: 1, Number: number, Value: value}
+}
+
+func TestConfigLoad(t *testing.T) {
+	tc := newTestConfigurator(t, testConfig)
+	if len(tc.Mapping.Mixer) != 4 || len(tc.Mapping.Shell) != 1 || len(tc.Mapping.Feedback) != 3 {
+		t.Fatalf("mappings weren't loaded: %+v", tc.Mapping)
+	}
+	if !tc.EchoMIDIEvents {
+		t.Error("echoMIDIEvents wasn't loaded")
+	}
+	if tc.API.Enabled {
+		t.Error("api should be off by default")
+	}
+	routes, ok := tc.routes.Load().(*routingTable)
+	if !ok {
+		t.Fatal("routes weren't stored")
+	}
+	if len(routes.mixerMappings(cc(1, 0))) != 1 {
+		t.Error("routes don't have the mixer mapping")
+	}
+	if events := tc.events.since(0); len(events) == 0 || events[len(events)-1].Type != eventConfig {
+		t.Errorf("expected a config event, got %+v", events)
+	}
+}
+
+func TestConfigInvalidLeavesEverythingAlone(t *testing.T) {
+	tc := newTestConfigurator(t, testConfig)
+	routes := tc.routes.Load()
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"mixer", "mapping:\n  mixer:\n    - cc: 200\n      filename: a.exe\n"},
+		{"shell", "mapping:\n  mixer:\n    - cc: 5\n      filename: a.exe\n  shell:\n    - cc: 200\n      command: echo\n"},
+		{"feedback", "mapping:\n  mixer:\n    - cc: 5\n      filename: a.exe\n  feedback:\n    - cc: 5\n      property: loudness\n      filename: a.exe\n"},
+		{"feedback onValue", "mapping:\n  mixer:\n    - cc: 5\n      filename: a.exe\n  feedback:\n    - cc: 5\n      property: mute\n      onValue: 128\n      filename: a.exe\n"},
+		{"yaml", "mapping: [\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc.load(t, tt.config)
+			if len(tc.Mapping.Mixer) != 4 || tc.Mapping.Mixer[0].Cc != 1 {
+				t.Error("mixer mappings shouldn't change")
+			}
+			if tc.routes.Load() != routes {
+				t.Error("routes shouldn't change")
+			}
+		})
+	}
+
+	// A bad API config is reported, but the mappings are still used.
+	tc.load(t, "api:\n  enabled: true\n  address: 10.0.0.1:7007\n  token: x\nmapping:\n  mixer:\n    - cc: 5\n      filename: a.exe\n")
+	if len(tc.Mapping.Mixer) != 1 || tc.Mapping.Mixer[0].Cc != 5 {
+		t.Error("mappings should be used even if the api config is bad")
+	}
+	if tc.API.Enabled {
+		t.Error("bad api config shouldn't be used")
+	}
+}
+
+func TestConfigRouting(t *testing.T) {
+	tc := newTestConfigurator(t, testConfig)
+
+	tc.midiMessageCallback(cc(1, 0))
+	waitFor(t, "the game volume", volumeIs(tc.game, 0))
+	if !volumeIs(tc.chat, 1)() {
+		t.Error("chat shouldn't change")
+	}
+
+	tc.midiMessageCallback(cc(2, 127))
+	waitFor(t, "the output volume", volumeIs(tc.speakers, 0.8))
+
+	// Note on & off both go to the note mapping, only the note on is a press.
+	tc.midiMessageCallback(message.Message{Kind: message.NoteOn, Channel: 1, Number: 60, Value: 127})
+	tc.midiMessageCallback(message.Message{Kind: message.NoteOff, Channel: 1, Number: 60, Value: 0})
+	waitFor(t, "the game to be muted", muteIs(tc.game, true))
+	waitFor(t, "chat to be muted", muteIs(tc.chat, true))
+
+	// The high resolution cc is combined from the MSB & LSB.
+	tc.midiMessageCallback(cc(3, 64))
+	tc.midiMessageCallback(cc(35, 0))
+	waitFor(t, "the headphones volume", volumeIs(tc.headphones, float32(64<<7)/16383))
+
+	midi, volume := 0, 0
+	for _, e := range tc.events.since(0) {
+		switch e.Type {
+		case eventMIDI:
+			midi++
+		case eventVolume:
+			volume++
+		}
+	}
+	if midi != 6 || volume == 0 {
+		t.Errorf("expected 6 midi events and some volume events, got %d and %d", midi, volume)
+	}
+}
+
+func TestConfigReloadRoutesNewMappings(t *testing.T) {
+	tc := newTestConfigurator(t, testConfig)
+	tc.load(t, "mapping:\n  mixer:\n    - cc: 9\n      filename: chat.exe\n")
+
+	tc.midiMessageCallback(cc(1, 0))
+	tc.midiMessageCallback(cc(9, 0))
+	waitFor(t, "the chat volume", volumeIs(tc.chat, 0))
+	if !volumeIs(tc.game, 1)() {
+		t.Error("the old mapping shouldn't be used after a reload")
+	}
+}
+
+func TestConfigFeedback(t *testing.T) {
+	tc := newTestConfigurator(t, testConfig)
+	if err := tc.game.SetExternalVolume(0.25); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.speakers.SetExternalVolume(0.35); err != nil {
+		t.Fatal(err)
+	}
+
+	// These are what feedbackLoop sends for the current state of the targets.
+	tests := []struct {
+		name   string
+		mute   bool
+		expect []int
+	}{
+		{"unmuted", false, []int{32, 32, 10}},
+		{"muted", true, []int{32, 32, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tc.game.SetExternalMute(tt.mute); err != nil {
+				t.Fatal(err)
+			}
+			for i, m := range tc.Mapping.Feedback {
+				volume, mute, ok := tc.audio.GetTargetState(m.Filename, m.Special, m.Device, tc.feedbackPatterns[i])
+				if !ok {
+					t.Fatalf("feedback %d target wasn't found", i)
+				}
+				if msg := m.Message(volume, mute); msg.Value != tt.expect[i] {
+					t.Errorf("feedback %d sent %d, expected %d", i, msg.Value, tt.expect[i])
+				}
+			}
+		})
+	}
+
+	select {
+	case <-tc.feedbackNow:
+	default:
+		t.Error("volume changes should have the feedback sent right away")
+	}
+}
+
+// The file watcher & reload requests both go through updateConfigFromDiskLoop, no mix of them should stop it.
+func TestConfigReloadLoop(t *testing.T) {
+	tc := newTestConfigurator(t, testConfig)
+	go tc.updateConfigFromDiskLoop()
+	mixerCc := func() int {
+		tc.Lock()
+		defer tc.Unlock()
+		if len(tc.Mapping.Mixer) != 1 {
+			return 0
+		}
+		return tc.Mapping.Mixer[0].Cc
+	}
+	waitForReload := func(cc int) {
+		t.Helper()
+		// The reloads are debounced by a second.
+		deadline := time.Now().Add(time.Second * 5)
+		for mixerCc() != cc {
+			if time.Now().After(deadline) {
+				t.Fatalf("config with cc %d wasn't reloaded", cc)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}
+	write := func(cc int) {
+		config := fmt.Sprintf("mapping:\n  mixer:\n    - cc: %d\n      filename: chat.exe\n", cc)
+		if err := ioutil.WriteFile(tc.filename, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The watcher has to be running before the writes are noticed.
+	time.Sleep(time.Millisecond * 100)
+	mux := tc.newAPIMux("secret")
+	for i := 10; i < 20; i++ {
+		tc.ReloadConfig()
+		write(i)
+		req := httptest.NewRequest(http.MethodPost, "/reload/config", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	waitForReload(19)
+
+	// Still going after all of that.
+	write(42)
+	waitForReload(42)
+}
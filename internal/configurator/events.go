@@ -0,0 +1,85 @@
+package configurator
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+)
+
+// eventsBuffer is how many of the latest events are kept around for the API.
+const eventsBuffer = 200
+
+// The types of events.
+const (
+	eventMIDI   = "midi"
+	eventVolume = "volume"
+	eventConfig = "config"
+)
+
+// event is something that happened, the ID goes up by one each time so the API can ask for what's new.
+type event struct {
+	ID     uint64              `json:"id"`
+	Time   time.Time           `json:"time"`
+	Type   string              `json:"type"`
+	MIDI   *midiEvent          `json:"midi,omitempty"`
+	Volume *audio.VolumeChange `json:"volume,omitempty"`
+}
+
+// midiEvent is a MIDI message, with the type as it's written in the config.
+type midiEvent struct {
+	Type           string `json:"type"`
+	Channel        int    `json:"channel"`
+	Number         int    `json:"number"`
+	Value          int    `json:"value"`
+	Device         string `json:"device,omitempty"`
+	HighResolution bool   `json:"highResolution,omitempty"`
+}
+
+// eventLog keeps the latest events, the oldest are dropped once it's full.
+type eventLog struct {
+	events []event
+	lastID uint64
+	sync.Mutex
+}
+
+func (l *eventLog) add(e event) {
+	l.Lock()
+	defer l.Unlock()
+	l.lastID++
+	e.ID = l.lastID
+	e.Time = time.Now()
+	if len(l.events) >= eventsBuffer {
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.events = append(l.events, e)
+}
+
+func (l *eventLog) addMIDIMessage(msg message.Message) {
+	l.add(event{Type: eventMIDI, MIDI: &midiEvent{
+		Type:           msg.Kind.String(),
+		Channel:        msg.Channel,
+		Number:         msg.Number,
+		Value:          msg.Value,
+		Device:         msg.Device,
+		HighResolution: msg.HighResolution,
+	}})
+}
+
+func (l *eventLog) addVolumeChange(change audio.VolumeChange) {
+	l.add(event{Type: eventVolume, Volume: &change})
+}
+
+// since returns the events after the one with the ID, oldest first.
+func (l *eventLog) since(id uint64) []event {
+	l.Lock()
+	defer l.Unlock()
+	events := []event{}
+	for _, e := range l.events {
+		if e.ID > id {
+			events = append(events, e)
+		}
+	}
+	return events
+}
@@ -0,0 +1,99 @@
+package configurator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/feedback"
+	"github.com/GregoryDosh/automidically/internal/midi"
+)
+
+// feedbackInterval is how often the feedback targets are checked for changes to send to the MIDI devices.
+const feedbackInterval = time.Millisecond * 250
+
+// onVolumeChanged has the feedback sent right away instead of waiting for the next poll.
+func (c *Configurator) onVolumeChanged(change audio.VolumeChange) {
+	select {
+	case c.feedbackNow <- true:
+	default:
+	}
+}
+
+// feedbackLoop polls the state of the feedback targets and sends a message to the MIDI devices whenever it changes.
+// Changes to volumes also have it check right away, the polling is for devices that connect.
+// The last value sent is remembered per mapping and device so unchanged states aren't sent over and over again,
+// it's forgotten when a device disconnects so it'll get the current state once it's back.
+func (c *Configurator) feedbackLoop() {
+	log.Trace("Enter feedbackLoop")
+	defer log.Trace("Exit feedbackLoop")
+
+	ticker := time.NewTicker(feedbackInterval)
+	defer ticker.Stop()
+
+	generation := -1
+	lastSent := map[string]int{}
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.feedbackNow:
+		}
+
+		c.Lock()
+		if c.audio == nil || c.shuttingDown {
+			c.Unlock()
+			if c.shuttingDown {
+				return
+			}
+			continue
+		}
+		if generation != c.feedbackGeneration {
+			generation = c.feedbackGeneration
+			lastSent = map[string]int{}
+		}
+		mappings := c.Mapping.Feedback
+		patterns := c.feedbackPatterns
+		devices := []*midi.Device{}
+		for _, d := range c.MIDIDevices {
+			if d != nil {
+				devices = append(devices, d)
+			}
+		}
+		c.Unlock()
+
+		for i, m := range mappings {
+			volume, mute, ok := c.audio.GetTargetState(m.Filename, m.Special, m.Device, patterns[i])
+			if !ok {
+				continue
+			}
+			msg := m.Message(volume, mute)
+			for _, d := range devices {
+				key := fmt.Sprintf("%d/%s", i, d.Name)
+				if !feedbackForDevice(&m, d) {
+					continue
+				}
+				if !d.Connected() {
+					delete(lastSent, key)
+					continue
+				}
+				if last, ok := lastSent[key]; ok && last == msg.Value {
+					continue
+				}
+				if err := d.Send(msg); err != nil {
+					if !errors.Is(err, midi.ErrorNoOutputDevice) {
+						log.Error(err)
+					}
+					continue
+				}
+				lastSent[key] = msg.Value
+			}
+		}
+	}
+}
+
+// feedbackForDevice reports if the feedback mapping should be sent to the device.
+func feedbackForDevice(m *feedback.Mapping, d *midi.Device) bool {
+	return m.MIDIDevice == "" || strings.EqualFold(m.MIDIDevice, d.Name)
+}
@@ -0,0 +1,131 @@
+package configurator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/GregoryDosh/automidically/internal/midi"
+	"github.com/GregoryDosh/automidically/internal/systray"
+)
+
+// MIDIDeviceNames maps the name a mapping uses for a device to the string searched for in the MIDI inputs.
+type MIDIDeviceNames map[string]string
+
+// UnmarshalYAML accepts a single device, a list of devices, or a table of named devices.
+// When there's no explicit name, the search string doubles as the name.
+func (n *MIDIDeviceNames) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	names := MIDIDeviceNames{}
+
+	var single string
+	if err := unmarshal(&single); err == nil {
+		if single != "" {
+			names[single] = single
+		}
+		*n = names
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		for _, s := range list {
+			names[s] = s
+		}
+		*n = names
+		return nil
+	}
+
+	var table map[string]string
+	if err := unmarshal(&table); err != nil {
+		return fmt.Errorf("midiDevicename should be a name, list of names, or table of names: %w", err)
+	}
+	for k, v := range table {
+		names[k] = v
+	}
+	*n = names
+	return nil
+}
+
+// lookup finds the search string for a device name, ignoring case like the rest of the config.
+func (n MIDIDeviceNames) lookup(name string) (string, bool) {
+	for k, v := range n {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// updateMIDIDevices will cleanup any devices that were removed or changed, and create any new ones.
+// Devices that haven't changed are left alone so they keep listening through a reload.
+// This expects the configurator to be locked already.
+func (c *Configurator) updateMIDIDevices(names MIDIDeviceNames) {
+	if c.MIDIDevices == nil {
+		c.MIDIDevices = map[string]*midi.Device{}
+	}
+
+	for name, d := range c.MIDIDevices {
+		if search, ok := names[name]; ok && strings.EqualFold(search, c.MIDIDeviceNames[name]) {
+			continue
+		}
+		log.Tracef("MIDI device %s removed or changed", name)
+		if d != nil {
+			if err := d.Cleanup(); err != nil {
+				log.Error(err)
+			}
+		}
+		delete(c.MIDIDevices, name)
+	}
+
+	for name, search := range names {
+		if _, ok := c.MIDIDevices[name]; ok {
+			continue
+		}
+		c.MIDIDevices[name] = midi.New(name, search, midiDeviceStatus)
+	}
+
+	if len(names) == 0 {
+		log.Error("missing MIDI device name")
+	}
+	c.MIDIDeviceNames = names
+}
+
+// midiDeviceStatus shows if the MIDI devices are connected in the systray menu.
+func midiDeviceStatus(name string, status midi.Status) {
+	if status == midi.StatusRemoved {
+		systray.RemoveMIDIDevice(name)
+		return
+	}
+	systray.SetMIDIDeviceStatus(name, status == midi.StatusConnected)
+}
+
+// cleanupMIDIDevices closes all of the MIDI devices, this expects the configurator to be locked already.
+func (c *Configurator) cleanupMIDIDevices() {
+	for name, d := range c.MIDIDevices {
+		if d != nil {
+			if err := d.Cleanup(); err != nil {
+				log.Error(err)
+			}
+		}
+		delete(c.MIDIDevices, name)
+	}
+}
+
+// warnUnknownMIDIDevices lets the user know if a mapping refers to a MIDI device that isn't configured,
+// since those mappings would never be triggered.
+func (c *Configurator) warnUnknownMIDIDevices() {
+	names := []string{}
+	for _, m := range c.Mapping.Mixer {
+		names = append(names, m.MIDIDevice)
+	}
+	for _, m := range c.Mapping.Shell {
+		names = append(names, m.MIDIDevice)
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if _, ok := c.MIDIDeviceNames.lookup(name); !ok {
+			log.Warnf("mapping refers to unknown MIDI device '%s'", name)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package configurator
+
+import (
+	"fmt"
+
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+	"github.com/GregoryDosh/automidically/internal/mixer"
+	"github.com/GregoryDosh/automidically/internal/shell"
+)
+
+// routeKey is the part of a message that's used to narrow down the mappings that could match it.
+type routeKey struct {
+	kind           message.Kind
+	number         int
+	highResolution bool
+}
+
+// routingTable is an index of the mappings by the messages they respond to. It's built on every reload and never
+// changed afterwards, so the MIDI callback can use it without holding the configurator's lock. The patterns of the
+// mixer mappings are compiled into them while building it, so matching doesn't need any locks either.
+type routingTable struct {
+	echoMIDIEvents bool
+	// mixers are the copies of the mixer mappings in the table, in the order of the config and with their IDs set.
+	mixers []mixer.Mapping
+	mixer  map[routeKey][]*mixer.Mapping
+	shell  map[routeKey][]*shell.Mapping
+}
+
+// triggerRouteKeys lists the keys a trigger should be indexed under. A note trigger matches both NoteOn & NoteOff.
+func triggerRouteKeys(t *message.Trigger) []routeKey {
+	kinds := []message.Kind{t.Type}
+	if t.Type == message.Note {
+		kinds = []message.Kind{message.NoteOn, message.NoteOff}
+	}
+	// Building a message from the trigger gives the number it'd be sent with, the same as the lookup will use.
+	number := t.Message(0).Number
+	keys := []routeKey{}
+	for _, k := range kinds {
+		keys = append(keys, routeKey{kind: k, number: number, highResolution: t.HighResolution})
+	}
+	return keys
+}
+
+func messageRouteKey(msg message.Message) routeKey {
+	return routeKey{kind: msg.Kind, number: msg.Number, highResolution: msg.HighResolution}
+}
+
+// newRoutingTable copies the mappings so later changes to the config can't affect the table.
+func newRoutingTable(mapping MappingOptions, echoMIDIEvents bool) *routingTable {
+	t := &routingTable{
+		echoMIDIEvents: echoMIDIEvents,
+		mixer:          map[routeKey][]*mixer.Mapping{},
+		shell:          map[routeKey][]*shell.Mapping{},
+	}
+	mixers := make([]mixer.Mapping, len(mapping.Mixer))
+	copy(mixers, mapping.Mixer)
+	copies := map[string]int{}
+	for i := range mixers {
+		m := &mixers[i]
+		id := m.Key()
+		m.ID = fmt.Sprintf("%s #%d", id, copies[id])
+		copies[id]++
+		m.CompilePatterns()
+		for _, key := range triggerRouteKeys(&m.Trigger) {
+			t.mixer[key] = append(t.mixer[key], m)
+		}
+	}
+	t.mixers = mixers
+	shells := make([]shell.Mapping, len(mapping.Shell))
+	copy(shells, mapping.Shell)
+	for i := range shells {
+		m := &shells[i]
+		for _, key := range triggerRouteKeys(&m.Trigger) {
+			t.shell[key] = append(t.shell[key], m)
+		}
+	}
+	return t
+}
+
+// mixerMappings returns the mixer mappings that could match the message, they still need to check the channel & device.
+func (t *routingTable) mixerMappings(msg message.Message) []*mixer.Mapping {
+	return t.mixer[messageRouteKey(msg)]
+}
+
+// shellMappings returns the shell mappings that could match the message, they still need to check the channel & device.
+func (t *routingTable) shellMappings(msg message.Message) []*shell.Mapping {
+	return t.shell[messageRouteKey(msg)]
+}
@@ -0,0 +1,193 @@
+package configurator
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+	"gopkg.in/yaml.v3"
+)
+
+func mappingOptionsFromYAML(t testing.TB, s string) MappingOptions {
+	t.Helper()
+	var mapping MappingOptions
+	if err := yaml.Unmarshal([]byte(s), &mapping); err != nil {
+		t.Fatal(err)
+	}
+	return mapping
+}
+
+func TestRoutingTable(t *testing.T) {
+	mapping := mappingOptionsFromYAML(t, `
+mixer:
+  - cc: 7
+    filename: a.exe
+  - cc: 7
+    channel: 2
+    filename: glob:b*.exe
+  - type: note
+    note: 60
+    action: toggleMute
+    special: output
+  - cc: 1
+    highResolution: true
+    special: output
+shell:
+  - cc: 7
+    command: echo
+  - type: programChange
+    program: 3
+    command: echo
+`)
+	routes := newRoutingTable(mapping, false)
+
+	tests := []struct {
+		name   string
+		msg    message.Message
+		mixer  int
+		shell  int
+		mixers []int
+	}{
+		{"cc", message.Message{Kind: message.ControlChange, Channel: 1, Number: 7}, 2, 1, []int{0, 1}},
+		{"other cc", message.Message{Kind: message.ControlChange, Channel: 1, Number: 8}, 0, 0, nil},
+		{"note on", message.Message{Kind: message.NoteOn, Channel: 1, Number: 60}, 1, 0, []int{2}},
+		{"note off", message.Message{Kind: message.NoteOff, Channel: 1, Number: 60}, 1, 0, []int{2}},
+		{"high resolution", message.Message{Kind: message.ControlChange, Channel: 1, Number: 1, HighResolution: true}, 1, 0, []int{3}},
+		{"half of high resolution", message.Message{Kind: message.ControlChange, Channel: 1, Number: 1}, 0, 0, nil},
+		{"program change", message.Message{Kind: message.ProgramChange, Channel: 1, Number: 3}, 0, 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mixers := routes.mixerMappings(tt.msg)
+			if len(mixers) != tt.mixer {
+				t.Fatalf("found %d mixer mappings, expected %d", len(mixers), tt.mixer)
+			}
+			for i, m := range mixers {
+				if m.Key() != mapping.Mixer[tt.mixers[i]].Key() {
+					t.Errorf("mixer mapping %d isn't mapping %d of the config", i, tt.mixers[i])
+				}
+			}
+			if shells := routes.shellMappings(tt.msg); len(shells) != tt.shell {
+				t.Errorf("found %d shell mappings, expected %d", len(shells), tt.shell)
+			}
+		})
+	}
+
+	// The table has its own copies, with the patterns compiled.
+	m := routes.mixerMappings(message.Message{Kind: message.ControlChange, Number: 7})[1]
+	if m == &mapping.Mixer[1] {
+		t.Error("routing table should copy the mappings")
+	}
+	if _, ok := m.Patterns["glob:b*.exe"]; !ok {
+		t.Error("routing table should compile the patterns of the mappings")
+	}
+	if !m.Patterns.Match("glob:b*.exe", "Browser.exe") {
+		t.Error("compiled glob should match")
+	}
+}
+
+func TestRoutingTableIDs(t *testing.T) {
+	mapping := mappingOptionsFromYAML(t, `
+mixer:
+  - cc: 1
+    filename: a.exe
+    takeover: pickup
+  - cc: 1
+    filename: a.exe
+    takeover: pickup
+  - cc: 2
+    special: output
+`)
+	ids := func(mapping MappingOptions) []string {
+		routes := newRoutingTable(mapping, false)
+		ids := []string{}
+		for _, cc := range []int{1, 2} {
+			for _, m := range routes.mixerMappings(message.Message{Kind: message.ControlChange, Number: cc}) {
+				ids = append(ids, m.ID)
+			}
+		}
+		return ids
+	}
+	before := ids(mapping)
+	if before[0] == before[1] {
+		t.Error("copies of the same mapping should have their own IDs")
+	}
+
+	// Adding a mapping ahead of the others doesn't change their IDs, so they keep their state.
+	inserted := mappingOptionsFromYAML(t, "mixer:\n  - cc: 3\n    filename: b.exe\n")
+	inserted.Mixer = append(inserted.Mixer, mapping.Mixer...)
+	if after := ids(inserted); !reflect.DeepEqual(after, before) {
+		t.Errorf("IDs changed from %v to %v", before, after)
+	}
+
+	// Changing a mapping gives it a new ID, so it starts over.
+	mapping.Mixer[2].VolumeMax = 0.5
+	if after := ids(mapping); after[2] == before[2] {
+		t.Error("a changed mapping should get a new ID")
+	}
+}
+
+// BenchmarkRoute sends messages through the MIDI callback with a big config, the mixer changes are applied to
+// a Fake backend by the target workers while it runs.
+func BenchmarkRoute(b *testing.B) {
+	var config strings.Builder
+	config.WriteString("mixer:\n")
+	for i := 0; i < 400; i++ {
+		fmt.Fprintf(&config, "  - cc: %d\n    channel: %d\n", i%128, i/128+1)
+		switch i % 4 {
+		case 0:
+			fmt.Fprintf(&config, "    filename: app%d.exe\n", i)
+		case 1:
+			fmt.Fprintf(&config, "    filename: glob:app%d*.exe\n    processTree: true\n", i)
+		case 2:
+			fmt.Fprintf(&config, "    title: regex:^Window %d\n    sessionDevice: contains:speakers\n", i)
+		case 3:
+			fmt.Fprintf(&config, "    device: contains:device %d\n", i%8)
+		}
+	}
+	config.WriteString("shell:\n")
+	for i := 0; i < 400; i++ {
+		// Channel 16 is never sent, so the shell mappings are routed to but never run anything.
+		fmt.Fprintf(&config, "  - cc: %d\n    channel: 16\n    command: echo %d\n", i%128, i)
+	}
+	mapping := mappingOptionsFromYAML(b, config.String())
+	for i := range mapping.Mixer {
+		if err := mapping.Mixer[i].Validate(); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	fake := audio.NewFake()
+	for i := 0; i < 8; i++ {
+		d := fake.AddDevice(fmt.Sprintf("%d", i), fmt.Sprintf("Device %d Speakers", i), audio.Output)
+		for j := 0; j < 50; j++ {
+			pid := i*50 + j
+			d.AddSession(audio.FakeProcess{
+				ProcessID:         pid,
+				ProcessExecutable: fmt.Sprintf("app%d.exe", pid),
+				Titles:            []string{fmt.Sprintf("Window %d", pid)},
+			}, audio.FakeProcess{ProcessID: 10000 + pid, ProcessExecutable: "launcher.exe"})
+		}
+	}
+	r := audio.New(fake, nil)
+	defer r.Cleanup()
+
+	c := &Configurator{
+		audio:          r,
+		highResolution: message.NewHighResolutionTracker(),
+	}
+	c.routes.Store(newRoutingTable(mapping, false))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.midiMessageCallback(message.Message{
+			Kind:    message.ControlChange,
+			Channel: i%3 + 1,
+			Number:  i % 128,
+			Value:   i % 128,
+		})
+	}
+}
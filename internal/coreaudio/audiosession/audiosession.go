@@ -1,3 +1,6 @@
+//go:build windows
+// +build windows
+
 package audiosession
 
 import (
@@ -6,6 +9,7 @@ import (
 	"sync"
 	"unsafe"
 
+	"github.com/GregoryDosh/automidically/internal/process"
 	ole "github.com/go-ole/go-ole"
 	"github.com/mitchellh/go-ps"
 	"github.com/moutend/go-wca/pkg/wca"
@@ -23,9 +27,19 @@ const (
 )
 
 type AudioSession struct {
-	ProcessExecutable    string
+	ProcessExecutable string
+	ProcessID         int
+	ProcessPath       string
+	// DisplayName is set by some applications to describe the session, it's often empty.
+	DisplayName string
+	// Ancestors are the parent, grandparent, etc. of the process when the session was found, nearest first.
+	Ancestors            []ps.Process
 	audioSessionControl2 *wca.IAudioSessionControl2
 	simpleAudioVolume    *wca.ISimpleAudioVolume
+	events               *sessionEvents
+	live                 LiveState
+	onVolumeChanged      func(*AudioSession, bool)
+	liveLock             sync.Mutex
 	sync.Mutex
 }
 
@@ -34,16 +48,65 @@ func (a *AudioSession) Cleanup() error {
 	log.Tracef("cleaning up %s", a.ProcessExecutable)
 	a.Lock()
 	defer a.Unlock()
+	a.unregisterEvents()
 	if a.simpleAudioVolume != nil {
 		a.simpleAudioVolume.Release()
+		a.simpleAudioVolume = nil
 	}
 	if a.audioSessionControl2 != nil {
 		a.audioSessionControl2.Release()
+		a.audioSessionControl2 = nil
 	}
 	a.ProcessExecutable = ""
 	return nil
 }
 
+// GetVolumeLevel will get the volume of the audio session as a float on the scale of 0-1.
+func (a *AudioSession) GetVolumeLevel() (float32, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.simpleAudioVolume == nil {
+		return 0, ErrorUninitializedAudioSession
+	}
+	var v float32
+	if err := a.simpleAudioVolume.GetMasterVolume(&v); err != nil {
+		return 0, fmt.Errorf("error getting volume: %w", err)
+	}
+	return v, nil
+}
+
+// GetMute will get the mute state of the audio session.
+func (a *AudioSession) GetMute() (bool, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.simpleAudioVolume == nil {
+		return false, ErrorUninitializedAudioSession
+	}
+	var m bool
+	if err := a.simpleAudioVolume.GetMute(&m); err != nil {
+		return false, fmt.Errorf("error getting mute: %w", err)
+	}
+	return m, nil
+}
+
+// SetMute will mute or unmute the audio session.
+func (a *AudioSession) SetMute(m bool) error {
+	a.Lock()
+	defer a.Unlock()
+	if a.simpleAudioVolume == nil {
+		return ErrorUninitializedAudioSession
+	}
+	if err := a.simpleAudioVolume.SetMute(m, eventContext); err != nil {
+		// AUDCLNT_E_DEVICE_INVALIDATED
+		if oleErr, ok := err.(*ole.OleError); ok && oleErr.Code() == 0x88890004 {
+			return fmt.Errorf("audio session %s unavailable", a.ProcessExecutable)
+		}
+		return fmt.Errorf("error setting mute: %w", err)
+	}
+	a.setLiveMute(m)
+	return nil
+}
+
 // SetVolumeLevel takes a float between 0-1 and it will set the volume of the audio session to that value.
 func (a *AudioSession) SetVolumeLevel(v float32) error {
 	a.Lock()
@@ -53,13 +116,18 @@ func (a *AudioSession) SetVolumeLevel(v float32) error {
 		return fmt.Errorf("invalid volume level %f", v)
 	}
 
-	if err := a.simpleAudioVolume.SetMasterVolume(v, nil); err != nil {
+	if a.simpleAudioVolume == nil {
+		return ErrorUninitializedAudioSession
+	}
+
+	if err := a.simpleAudioVolume.SetMasterVolume(v, eventContext); err != nil {
 		// AUDCLNT_E_DEVICE_INVALIDATED
 		if err.(*ole.OleError).Code() == 0x88890004 {
 			return fmt.Errorf("audio session %s unavailable", a.ProcessExecutable)
 		}
 		return fmt.Errorf("error setting volume: %w", err)
 	}
+	a.setLiveVolume(v)
 
 	// Check if AudioSession is still active
 	var s uint32
@@ -109,16 +177,34 @@ func New(audioSessionEnumerator *wca.IAudioSessionEnumerator, audioSessionNumber
 
 	// Snagging the name of the process executible that created this audio session.
 	var processExecutable string
-	process, err := ps.FindProcess(int(processId))
+	p, err := ps.FindProcess(int(processId))
 	if err != nil {
 		return nil, fmt.Errorf("failed to find process: %w", err)
 	}
-	processExecutable = process.Executable()
+	processExecutable = p.Executable()
+
+	// Not every session has a display name so it's fine if this fails.
+	var displayName string
+	if err := audioSessionControl2.GetDisplayName(&displayName); err != nil {
+		log.Tracef("unable to get display name for %s: %s", processExecutable, err)
+	}
 
 	as := &AudioSession{
 		audioSessionControl2: audioSessionControl2,
 		simpleAudioVolume:    simpleAudioVolume,
 		ProcessExecutable:    processExecutable,
+		ProcessID:            int(processId),
+		ProcessPath:          process.Path(int(processId)),
+		DisplayName:          displayName,
+		Ancestors:            process.Ancestors(int(processId)),
+	}
+
+	// Keeping up with changes made outside of Automidically, like in the Windows volume mixer.
+	if err := as.RefreshLiveState(); err != nil {
+		log.Tracef("unable to get the state of %s: %s", processExecutable, err)
+	}
+	if err := as.registerEvents(); err != nil {
+		log.Errorf("unable to watch %s for changes: %s", processExecutable, err)
 	}
 
 	return as, nil
@@ -0,0 +1,156 @@
+//go:build windows
+// +build windows
+
+package audiosession
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+
+	ole "github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+var (
+	// eventContext is passed along with the changes made by Automidically, so they can be told apart from the
+	// changes made in the Windows volume mixer or the applications themselves.
+	eventContext = ole.NewGUID("{6E1D2B0C-0F5B-4B8E-9C55-6F2E3A7A5D41}")
+
+	sessionEventsOnce sync.Once
+	sessionEventsVtbl *wca.IAudioSessionEventsVtbl
+)
+
+// LiveState is the volume and mute of an audio session as of the last change, ok is false if it isn't known.
+type LiveState struct {
+	Volume float32
+	Mute   bool
+	ok     bool
+}
+
+// sessionEvents is the IAudioSessionEvents handed to Windows for an audio session. It starts with the vtable
+// like any COM object, and the session it belongs to follows where Windows won't look.
+type sessionEvents struct {
+	vtbl    *wca.IAudioSessionEventsVtbl
+	session *AudioSession
+}
+
+// getSessionEventsVtbl creates the callbacks once for every session, Windows only allows so many of them.
+// Callbacks with float arguments can't be read from Go, so the new volume is looked up instead of taken from
+// OnSimpleVolumeChanged, and the rest that aren't needed do nothing but take the right number of arguments.
+func getSessionEventsVtbl() *wca.IAudioSessionEventsVtbl {
+	sessionEventsOnce.Do(func() {
+		sessionEventsVtbl = &wca.IAudioSessionEventsVtbl{
+			QueryInterface: syscall.NewCallback(func(this *sessionEvents, riid *ole.GUID, ppv *uintptr) uintptr {
+				if ole.IsEqualGUID(riid, ole.IID_IUnknown) || ole.IsEqualGUID(riid, wca.IID_IAudioSessionEvents) {
+					*ppv = uintptr(unsafe.Pointer(this))
+					return ole.S_OK
+				}
+				*ppv = 0
+				return ole.E_NOINTERFACE
+			}),
+			AddRef: syscall.NewCallback(func(this *sessionEvents) uintptr {
+				return 1
+			}),
+			Release: syscall.NewCallback(func(this *sessionEvents) uintptr {
+				return 1
+			}),
+			OnDisplayNameChanged: syscall.NewCallback(func(this *sessionEvents, name, ctx uintptr) uintptr {
+				return ole.S_OK
+			}),
+			OnIconPathChanged: syscall.NewCallback(func(this *sessionEvents, path, ctx uintptr) uintptr {
+				return ole.S_OK
+			}),
+			OnSimpleVolumeChanged: syscall.NewCallback(func(this *sessionEvents, volume, mute uintptr, ctx *ole.GUID) uintptr {
+				this.session.changed(ctx == nil || !ole.IsEqualGUID(ctx, eventContext))
+				return ole.S_OK
+			}),
+			OnChannelVolumeChanged: syscall.NewCallback(func(this *sessionEvents, count, volumes, channel, ctx uintptr) uintptr {
+				return ole.S_OK
+			}),
+			OnGroupingParamChanged: syscall.NewCallback(func(this *sessionEvents, grouping, ctx uintptr) uintptr {
+				return ole.S_OK
+			}),
+			OnStateChanged: syscall.NewCallback(func(this *sessionEvents, state uintptr) uintptr {
+				return ole.S_OK
+			}),
+			OnSessionDisconnected: syscall.NewCallback(func(this *sessionEvents, reason uintptr) uintptr {
+				return ole.S_OK
+			}),
+		}
+	})
+	return sessionEventsVtbl
+}
+
+// registerEvents asks Windows to tell us about changes to the session.
+func (a *AudioSession) registerEvents() error {
+	a.events = &sessionEvents{
+		vtbl:    getSessionEventsVtbl(),
+		session: a,
+	}
+	return a.audioSessionControl2.RegisterAudioSessionNotification((*wca.IAudioSessionEvents)(unsafe.Pointer(a.events)))
+}
+
+// unregisterEvents stops the notifications, this expects the session to be locked already.
+func (a *AudioSession) unregisterEvents() {
+	if a.events == nil || a.audioSessionControl2 == nil {
+		return
+	}
+	if err := a.audioSessionControl2.UnregisterAudioSessionNotification((*wca.IAudioSessionEvents)(unsafe.Pointer(a.events))); err != nil {
+		log.Error(err)
+	}
+	a.events = nil
+}
+
+// OnVolumeChanged sets a function to be called when the volume or mute of the session changes. external is true
+// when the change wasn't made by Automidically. It's called from a Windows thread so it shouldn't block, and the
+// new state isn't known yet, RefreshLiveState can be used for that.
+func (a *AudioSession) OnVolumeChanged(fn func(a *AudioSession, external bool)) {
+	a.liveLock.Lock()
+	defer a.liveLock.Unlock()
+	a.onVolumeChanged = fn
+}
+
+func (a *AudioSession) changed(external bool) {
+	a.liveLock.Lock()
+	fn := a.onVolumeChanged
+	a.liveLock.Unlock()
+	if fn != nil {
+		fn(a, external)
+	}
+}
+
+// LiveState returns the volume & mute of the session without asking Windows, as of the last change.
+func (a *AudioSession) LiveState() (volume float32, mute bool, ok bool) {
+	a.liveLock.Lock()
+	defer a.liveLock.Unlock()
+	return a.live.Volume, a.live.Mute, a.live.ok
+}
+
+// RefreshLiveState looks up the current volume & mute of the session to keep LiveState up to date.
+func (a *AudioSession) RefreshLiveState() error {
+	v, err := a.GetVolumeLevel()
+	if err != nil {
+		return err
+	}
+	m, err := a.GetMute()
+	if err != nil {
+		return err
+	}
+	a.liveLock.Lock()
+	defer a.liveLock.Unlock()
+	a.live = LiveState{Volume: v, Mute: m, ok: true}
+	return nil
+}
+
+func (a *AudioSession) setLiveVolume(v float32) {
+	a.liveLock.Lock()
+	defer a.liveLock.Unlock()
+	a.live.Volume = v
+}
+
+func (a *AudioSession) setLiveMute(m bool) {
+	a.liveLock.Lock()
+	defer a.liveLock.Unlock()
+	a.live.Mute = m
+}
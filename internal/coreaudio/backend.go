@@ -0,0 +1,185 @@
+//go:build windows
+// +build windows
+
+package coreaudio
+
+import (
+	"errors"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/coreaudio/audiosession"
+	"github.com/GregoryDosh/automidically/internal/coreaudio/device"
+	"github.com/GregoryDosh/automidically/internal/process"
+	"github.com/mitchellh/go-ps"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+// endpoint is a *device.Device as an audio.Device. There's one per device so it can be told apart by the Router,
+// and the id & name are looked up once while the device is known to be good.
+type endpoint struct {
+	*device.Device
+	ca   *CoreAudio
+	id   string
+	name string
+}
+
+func newEndpoint(ca *CoreAudio, d *device.Device) (*endpoint, error) {
+	id, err := d.ID()
+	if err != nil {
+		return nil, err
+	}
+	name, err := d.DeviceName()
+	if err != nil {
+		return nil, err
+	}
+	return &endpoint{Device: d, ca: ca, id: id, name: name}, nil
+}
+
+func (e *endpoint) ID() string   { return e.id }
+func (e *endpoint) Name() string { return e.name }
+
+func (e *endpoint) Flow() audio.Flow {
+	return audioFlow(e.Device.Flow)
+}
+
+func (e *endpoint) IsDefault(role audio.Role) bool {
+	return e.ca.isDefault(e.Device.Flow, windowsRole(role), e.id)
+}
+
+func (e *endpoint) Sessions() []audio.Session {
+	return wrapSessions(e.AudioSessions())
+}
+
+// session is an *audiosession.AudioSession as an audio.Session. It's a value so the same audio session is always
+// equal to itself, no matter how many times it's been wrapped.
+type session struct {
+	as *audiosession.AudioSession
+}
+
+func wrapSessions(sessions []*audiosession.AudioSession) []audio.Session {
+	wrapped := make([]audio.Session, len(sessions))
+	for i, as := range sessions {
+		wrapped[i] = session{as: as}
+	}
+	return wrapped
+}
+
+// sessionError turns the errors about audio sessions that have gone away into audio.ErrorSessionExpired.
+func sessionError(err error) error {
+	if errors.Is(err, audiosession.ErrorAudioSessionStateExpired) || errors.Is(err, audiosession.ErrorUninitializedAudioSession) {
+		return audio.ErrorSessionExpired
+	}
+	return err
+}
+
+func (s session) GetVolumeLevel() (float32, error) {
+	v, err := s.as.GetVolumeLevel()
+	return v, sessionError(err)
+}
+
+func (s session) SetVolumeLevel(v float32) error {
+	return sessionError(s.as.SetVolumeLevel(v))
+}
+
+func (s session) GetMute() (bool, error) {
+	m, err := s.as.GetMute()
+	return m, sessionError(err)
+}
+
+func (s session) SetMute(m bool) error {
+	return sessionError(s.as.SetMute(m))
+}
+
+func (s session) LiveState() (float32, bool, bool) {
+	return s.as.LiveState()
+}
+
+func (s session) Pid() int            { return s.as.ProcessID }
+func (s session) Executable() string  { return s.as.ProcessExecutable }
+func (s session) DisplayName() string { return s.as.DisplayName }
+
+func (s session) Path() string {
+	if s.as.ProcessPath != "" {
+		return s.as.ProcessPath
+	}
+	return process.Path(s.as.ProcessID)
+}
+
+func (s session) WindowTitles() []string {
+	return process.WindowTitles(s.as.ProcessID)
+}
+
+func (s session) IsSystem() bool {
+	return s.as.ProcessExecutable == audiosession.SystemAudioSession
+}
+
+func (s session) Ancestors() []audio.Process {
+	ancestors := make([]audio.Process, len(s.as.Ancestors))
+	for i, p := range s.as.Ancestors {
+		ancestors[i] = ancestor{p}
+	}
+	return ancestors
+}
+
+// ancestor is one of the parent processes of an audio session.
+type ancestor struct {
+	ps.Process
+}
+
+func (a ancestor) Path() string {
+	return process.Path(a.Pid())
+}
+
+func (a ancestor) WindowTitles() []string {
+	return process.WindowTitles(a.Pid())
+}
+
+// audioFlow & windowsRole translate between the audio package and what Windows uses.
+func audioFlow(flow device.Flow) audio.Flow {
+	if flow == device.Input {
+		return audio.Input
+	}
+	return audio.Output
+}
+
+func windowsRole(role audio.Role) wca.ERole {
+	switch role {
+	case audio.Communications:
+		return wca.ECommunications
+	case audio.Multimedia:
+		return wca.EMultimedia
+	}
+	return wca.EConsole
+}
+
+// View calls fn with all of the devices, holding the deviceLock so they can't be cleaned up in the meantime.
+func (ca *CoreAudio) View(fn func(devices []audio.Device)) {
+	ca.deviceLock.Lock()
+	defer ca.deviceLock.Unlock()
+	fn(ca.endpoints)
+}
+
+// RefreshDevices looks up all of the devices, and their audio sessions, again.
+func (ca *CoreAudio) RefreshDevices() {
+	ca.refreshHardwareDevicesChannel <- true
+}
+
+// RefreshSessions looks up the audio sessions of all of the devices again.
+func (ca *CoreAudio) RefreshSessions() {
+	ca.refreshAudioSessionsChannel <- true
+}
+
+// OnSessionsRefreshed calls fn with all of the audio sessions of a device each time they're refreshed.
+// The device is locked during the call.
+func (ca *CoreAudio) OnSessionsRefreshed(fn func(audio.Device, []audio.Session)) {
+	ca.callbacksLock.Lock()
+	defer ca.callbacksLock.Unlock()
+	ca.onSessionsRefreshed = fn
+}
+
+// OnDevicesRefreshed calls fn after the devices were refreshed, the deviceLock is held during the call.
+func (ca *CoreAudio) OnDevicesRefreshed(fn func()) {
+	ca.callbacksLock.Lock()
+	defer ca.callbacksLock.Unlock()
+	ca.onDevicesRefreshed = fn
+}
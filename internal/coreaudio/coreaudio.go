@@ -1,17 +1,17 @@
+//go:build windows
+// +build windows
+
 package coreaudio
 
 import (
 	"errors"
 	"fmt"
-	"math"
-	"strings"
 	"sync"
 	"time"
 
-	"github.com/GregoryDosh/automidically/internal/activewindow"
+	"github.com/GregoryDosh/automidically/internal/audio"
 	"github.com/GregoryDosh/automidically/internal/coreaudio/audiosession"
 	"github.com/GregoryDosh/automidically/internal/coreaudio/device"
-	"github.com/GregoryDosh/automidically/internal/mixer"
 	"github.com/GregoryDosh/automidically/internal/systray"
 	"github.com/bep/debounce"
 	ole "github.com/go-ole/go-ole"
@@ -22,19 +22,40 @@ import (
 var (
 	CoreAudioAlreadyInitialized = errors.New("CoInitializeEX returned S_FALSE -> Already initialized on this thread")
 	log                         = logrus.WithField("module", "coreaudio")
-	aw                          = activewindow.GetListener()
 )
 
+// CoreAudio is the audio.Backend for Windows, it keeps track of the devices & audio sessions through WASAPI.
 type CoreAudio struct {
-	inputDevice                   *device.Device
-	outputDevice                  *device.Device
 	allDevices                    []*device.Device
+	endpoints                     []audio.Device
 	refreshHardwareDevicesChannel chan bool
 	refreshAudioSessionsChannel   chan bool
+	defaultDeviceChangedChannel   chan defaultDeviceChange
+	volumeEventsChannel           chan volumeEvent
+	subscribers                   subscribers
 	deviceLock                    sync.Mutex
 	deviceEnumerator              *wca.IMMDeviceEnumerator
 	notificationClient            *wca.IMMNotificationClient
 	cleanupChan                   chan bool
+	defaults                      map[defaultRole]string
+	defaultsLock                  sync.Mutex
+	onSessionsRefreshed           func(audio.Device, []audio.Session)
+	onDevicesRefreshed            func()
+	callbacksLock                 sync.Mutex
+}
+
+// defaultRole is a kind of default device, Windows has one for each flow & role.
+type defaultRole struct {
+	flow device.Flow
+	role wca.ERole
+}
+
+// defaultDeviceChange is sent when Windows changes the default device of a flow for one of the roles.
+// An empty id means there isn't a default device anymore.
+type defaultDeviceChange struct {
+	flow device.Flow
+	role wca.ERole
+	id   string
 }
 
 // cleanupDevices is an internal function to do some more of the grunt work around the device cleanup process.
@@ -46,24 +67,16 @@ func (ca *CoreAudio) cleanupDevices() {
 	ca.deviceLock.Lock()
 	defer ca.deviceLock.Unlock()
 
-	if ca.outputDevice != nil {
-		if err := ca.outputDevice.Cleanup(); err != nil {
-			log.Error(err)
-		}
-		ca.outputDevice = nil
-	}
-	if ca.inputDevice != nil {
-		if err := ca.inputDevice.Cleanup(); err != nil {
-			log.Error(err)
-		}
-		ca.inputDevice = nil
-	}
+	ca.defaultsLock.Lock()
+	ca.defaults = map[defaultRole]string{}
+	ca.defaultsLock.Unlock()
 	for _, d := range ca.allDevices {
 		if err := d.Cleanup(); err != nil {
 			log.Error(err)
 		}
 	}
 	ca.allDevices = nil
+	ca.endpoints = nil
 }
 
 // coreAudioEventLoop is reponsible for the coordination of the logic in the coreaudio package. It will handle events
@@ -88,6 +101,8 @@ func (ca *CoreAudio) coreAudioEventLoop() {
 		case <-ca.refreshAudioSessionsChannel:
 			log.Trace("triggering audio session refresh")
 			dses(ca.refreshAudioSessions)
+		case c := <-ca.defaultDeviceChangedChannel:
+			ca.updateDefaultDevice(c)
 		}
 	}
 }
@@ -99,8 +114,8 @@ func (ca *CoreAudio) refreshAudioSessions() {
 	defer log.Trace("Exit refreshAudioSessions")
 	ca.deviceLock.Lock()
 	defer ca.deviceLock.Unlock()
-	if ca.outputDevice != nil {
-		if err := ca.outputDevice.RefreshAudioSessions(); err != nil {
+	for _, d := range ca.allDevices {
+		if err := d.RefreshAudioSessions(); err != nil {
 			log.Error(err)
 		}
 	}
@@ -112,8 +127,6 @@ func (ca *CoreAudio) refreshHardwareDevices() {
 	log.Trace("Enter refreshHardwareDevices")
 	defer log.Trace("Exit refreshHardwareDevices")
 
-	var err error
-
 	// Remove all stale and old pointers to things before creating new
 	ca.cleanupDevices()
 
@@ -122,86 +135,184 @@ func (ca *CoreAudio) refreshHardwareDevices() {
 	ca.deviceLock.Lock()
 	defer ca.deviceLock.Unlock()
 
-	// Default Output Device
-	var outDev *wca.IMMDevice
-	if err := ca.deviceEnumerator.GetDefaultAudioEndpoint(wca.ERender, wca.EConsole, &outDev); err != nil {
-		log.Warn("no default output device detected")
-	}
-	if outDev != nil {
-		if ca.outputDevice, err = device.New(outDev); err != nil {
-			if errors.Is(err, device.MissingAudioEndpointVolume) {
-				log.Debug(err)
-			} else {
-				log.Error(err)
-			}
-		}
-		if name, err := ca.outputDevice.DeviceName(); err != nil {
-			log.Infof("using default output device named: %s", name)
+	// Enumerate all audio devices, outputs and inputs, every one of them has its own audio sessions.
+	trayDevices := ca.enumerateDevices(device.Output)
+	trayDevices = append(trayDevices, ca.enumerateDevices(device.Input)...)
+	systray.SetAudioDevices(trayDevices)
+
+	// The default devices are picked out of all the devices so their audio sessions aren't tracked twice.
+	for _, flow := range []device.Flow{device.Output, device.Input} {
+		for _, role := range []wca.ERole{wca.EConsole, wca.ECommunications} {
+			ca.setDefaultDevice(flow, role, ca.defaultDevice(flow, role))
 		}
 	}
 
-	// Default Input Device
-	var inDev *wca.IMMDevice
-	if err := ca.deviceEnumerator.GetDefaultAudioEndpoint(wca.ECapture, wca.EConsole, &inDev); err != nil {
-		log.Warn("no default input device detected")
-	}
-	if inDev != nil {
-		if ca.inputDevice, err = device.New(inDev); err != nil {
-			if errors.Is(err, device.MissingAudioEndpointVolume) {
-				log.Debug(err)
-			} else {
-				log.Error(err)
-			}
-		}
-		if name, err := ca.inputDevice.DeviceName(); err != nil {
-			log.Infof("using default input device named: %s", name)
-		}
+	ca.callbacksLock.Lock()
+	onDevicesRefreshed := ca.onDevicesRefreshed
+	ca.callbacksLock.Unlock()
+	if onDevicesRefreshed != nil {
+		onDevicesRefreshed()
 	}
 
-	// Since the default devices change, refresh their audio sessions too.
+	// Since the devices changed, refresh their audio sessions too.
 	ca.refreshAudioSessionsChannel <- true
+}
+
+// enumerateDevices adds all of the active devices of the flow to allDevices, and returns them for the systray.
+// This expects the deviceLock to be held already.
+func (ca *CoreAudio) enumerateDevices(flow device.Flow) []systray.AudioDevice {
+	trayDevices := []systray.AudioDevice{}
 
-	// Enumerate all audio devices
 	var deviceCollection *wca.IMMDeviceCollection
-	if err := ca.deviceEnumerator.EnumAudioEndpoints(wca.ERender, wca.DEVICE_STATE_ACTIVE, &deviceCollection); err != nil {
+	if err := ca.deviceEnumerator.EnumAudioEndpoints(uint32(flow), wca.DEVICE_STATE_ACTIVE, &deviceCollection); err != nil {
 		log.Error(err)
+		return trayDevices
 	}
+	defer deviceCollection.Release()
 
 	var deviceCount uint32
 	if err := deviceCollection.GetCount(&deviceCount); err != nil {
 		log.Error(err)
 	}
 
-	deviceNames := []string{}
 	for i := uint32(0); i < deviceCount; i++ {
 		var mmd *wca.IMMDevice
 		if err := deviceCollection.Item(i, &mmd); err != nil {
 			log.Error(err)
 			continue
 		}
-		d, err := device.New(mmd)
+		d, err := device.New(mmd, flow)
 		if err != nil {
 			log.Error(err)
 			continue
 		}
-		if dn, err := d.DeviceName(); err == nil {
-			deviceNames = append(deviceNames, dn)
-			log.Debugf("found device named '%s'", dn)
+		e, err := newEndpoint(ca, d)
+		if err != nil {
+			log.Error(err)
+			if err := d.Cleanup(); err != nil {
+				log.Error(err)
+			}
+			continue
 		}
+		trayDevices = append(trayDevices, systray.AudioDevice{Name: e.name, Flow: flow.String()})
+		log.Debugf("found %s device named '%s'", flow, e.name)
+		d.OnAudioSessionsRefreshed(func(d *device.Device, sessions []*audiosession.AudioSession) {
+			ca.onAudioSessionsRefreshed(e, sessions)
+		})
+		ca.watchDevice(e)
 		ca.allDevices = append(ca.allDevices, d)
+		ca.endpoints = append(ca.endpoints, e)
+	}
+	return trayDevices
+}
+
+// roleName is used for logging which kind of default device is being used.
+func roleName(role wca.ERole) string {
+	if role == wca.ECommunications {
+		return "communications"
+	}
+	return "default"
+}
+
+// defaultDevice finds the default device of the flow & role in allDevices.
+// This expects the deviceLock to be held already.
+func (ca *CoreAudio) defaultDevice(flow device.Flow, role wca.ERole) *device.Device {
+	var mmd *wca.IMMDevice
+	if err := ca.deviceEnumerator.GetDefaultAudioEndpoint(uint32(flow), uint32(role), &mmd); err != nil || mmd == nil {
+		log.Warnf("no %s %s device detected", roleName(role), flow)
+		return nil
+	}
+	defer mmd.Release()
+
+	id, err := device.EndpointID(mmd)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	d := ca.findDevice(id)
+	if d == nil {
+		log.Warnf("%s %s device isn't active", roleName(role), flow)
+	}
+	return d
+}
+
+// setDefaultDevice keeps track of d as the default device of the flow & role.
+// This expects the deviceLock to be held already.
+func (ca *CoreAudio) setDefaultDevice(flow device.Flow, role wca.ERole, d *device.Device) {
+	id := ""
+	if d != nil {
+		id, _ = d.ID()
+		if name, err := d.DeviceName(); err == nil {
+			log.Infof("using %s %s device named: %s", roleName(role), flow, name)
+		}
+	}
+
+	// The defaults have their own lock since they're also needed while a device is busy refreshing its sessions.
+	ca.defaultsLock.Lock()
+	defer ca.defaultsLock.Unlock()
+	ca.defaults[defaultRole{flow: flow, role: role}] = id
+}
+
+// isDefault reports if the device with the id is the default of the flow for the role. Windows changes the
+// multimedia default along with console, so console stands in for it.
+func (ca *CoreAudio) isDefault(flow device.Flow, role wca.ERole, id string) bool {
+	if role == wca.EMultimedia {
+		role = wca.EConsole
+	}
+	ca.defaultsLock.Lock()
+	defer ca.defaultsLock.Unlock()
+	return id != "" && ca.defaults[defaultRole{flow: flow, role: role}] == id
+}
+
+// updateDefaultDevice switches over to the new default device of a role, all of the devices are already known
+// so there's no need to refresh everything. This should only get called from the core audio event loop.
+func (ca *CoreAudio) updateDefaultDevice(c defaultDeviceChange) {
+	log.Trace("Enter updateDefaultDevice")
+	defer log.Trace("Exit updateDefaultDevice")
+	ca.deviceLock.Lock()
+	defer ca.deviceLock.Unlock()
+
+	var d *device.Device
+	if c.id != "" {
+		if d = ca.findDevice(c.id); d == nil {
+			// Probably a device that was just added, which refreshes everything anyway.
+			log.Debugf("new %s %s device isn't known yet", roleName(c.role), c.flow)
+			ca.refreshHardwareDevicesChannel <- true
+			return
+		}
 	}
-	systray.SetAudioDevices(deviceNames)
+	ca.setDefaultDevice(c.flow, c.role, d)
 }
 
+// findDevice returns the device from allDevices with the endpoint id, or nil if there isn't one.
+// This expects the deviceLock to be held already.
+func (ca *CoreAudio) findDevice(id string) *device.Device {
+	for _, d := range ca.allDevices {
+		if did, err := d.ID(); err == nil && did == id {
+			return d
+		}
+	}
+	return nil
+}
+
+// onDefaultDeviceChanged only updates the one default device that changed. The console & multimedia roles are
+// changed together by Windows, so multimedia is ignored.
 func (ca *CoreAudio) onDefaultDeviceChanged(flow wca.EDataFlow, role wca.ERole, pwstrDeviceId string) error {
+	var f device.Flow
 	if flow == wca.ERender {
-		log.Trace("detected onDefaultDeviceChanged event: output")
+		log.Tracef("detected onDefaultDeviceChanged event: output %s", roleName(role))
+		f = device.Output
 	} else if flow == wca.ECapture {
-		log.Trace("detected onDefaultDeviceChanged event: input")
+		log.Tracef("detected onDefaultDeviceChanged event: input %s", roleName(role))
+		f = device.Input
 	} else {
 		log.Trace("detected onDefaultDeviceChanged event: unknown")
+		return nil
 	}
-	ca.refreshHardwareDevicesChannel <- true
+	if role == wca.EMultimedia {
+		return nil
+	}
+	ca.defaultDeviceChangedChannel <- defaultDeviceChange{flow: f, role: role, id: pwstrDeviceId}
 	return nil
 }
 
@@ -243,98 +354,6 @@ func (ca *CoreAudio) Cleanup() error {
 	return nil
 }
 
-// HandleMIDIMessage will take a *mixer.Mapping, and the MIDI's channel c, along with the value sent v, to peform the necessary logic
-// of refreshing devices, setting volumes of audio sessions, devices, and other potential scenarios.
-func (ca *CoreAudio) HandleMIDIMessage(m *mixer.Mapping, c int, v int) {
-	if m.Cc != c {
-		return
-	}
-
-	ca.deviceLock.Lock()
-	defer ca.deviceLock.Unlock()
-	clampedValue := clampValue(v, m.HardwareMin, m.HardwareMax)
-	volumeLevel := mapValue(clampedValue, m.HardwareMin, m.HardwareMax, m.VolumeMin, m.VolumeMax)
-
-	// special
-	for _, s := range m.Special {
-		// refreshDevices
-		if strings.EqualFold(s, "refreshDevices") {
-			ca.refreshHardwareDevicesChannel <- true
-		}
-		// refreshSessions
-		if strings.EqualFold(s, "refreshSessions") {
-			ca.refreshAudioSessionsChannel <- true
-		}
-		// active
-		if strings.EqualFold(s, "active") {
-			activeWindowFilename := aw.ProcessFilename()
-			if ca.outputDevice != nil && activeWindowFilename != "" {
-				if err := ca.outputDevice.SetAudioSessionVolumeLevel(activeWindowFilename, volumeLevel); err != nil {
-					if !errors.Is(err, device.AudioSessionNotFound) {
-						log.Error(err)
-					}
-				}
-			}
-		}
-		// output
-		if strings.EqualFold(s, "output") {
-			if ca.outputDevice != nil {
-				if err := ca.outputDevice.SetVolumeLevel(volumeLevel); err != nil {
-					log.Error(err)
-				}
-			}
-		}
-		// input
-		if strings.EqualFold(s, "input") {
-			if ca.inputDevice != nil {
-				if err := ca.inputDevice.SetVolumeLevel(volumeLevel); err != nil {
-					log.Error(err)
-				}
-			}
-		}
-		// system
-		if strings.EqualFold(s, "system") {
-			if ca.outputDevice != nil {
-				if err := ca.outputDevice.SetAudioSessionVolumeLevel(audiosession.SystemAudioSession, volumeLevel); err != nil {
-					log.Error(err)
-				}
-			}
-		}
-	}
-
-	// filename
-	if ca.outputDevice != nil {
-		for _, f := range m.Filename {
-			if err := ca.outputDevice.SetAudioSessionVolumeLevel(f, volumeLevel); err != nil {
-				if !errors.Is(err, device.AudioSessionNotFound) {
-					log.Error(err)
-				}
-			}
-		}
-	}
-
-	// device
-	for _, dn := range m.Device {
-		for _, d := range ca.allDevices {
-			if name, _ := d.DeviceName(); strings.EqualFold(name, dn) {
-				if err := d.SetVolumeLevel(volumeLevel); err != nil {
-					log.Error(err)
-				}
-			}
-		}
-	}
-}
-
-// HandleSystrayMessage takes messages from systray and will act accordingy.
-func (ca *CoreAudio) HandleSystrayMessage(msg systray.Message) {
-	switch msg {
-	case systray.SystrayRefreshDevices:
-		ca.refreshHardwareDevicesChannel <- true
-	case systray.SystrayRefreshSessions:
-		ca.refreshAudioSessionsChannel <- true
-	}
-}
-
 // New will create a new CoreAudio interface and start all the event loops and bindings necessasry to inderact with Window's Audio APIs
 func New() (*CoreAudio, error) {
 	// CoInitializeEx must be called at least once, and is usually called only once, for each thread that uses the COM library.
@@ -351,7 +370,10 @@ func New() (*CoreAudio, error) {
 	ca := &CoreAudio{
 		refreshHardwareDevicesChannel: make(chan bool, 20),
 		refreshAudioSessionsChannel:   make(chan bool, 20),
+		defaultDeviceChangedChannel:   make(chan defaultDeviceChange, 20),
+		volumeEventsChannel:           make(chan volumeEvent, volumeEventsBuffer),
 		cleanupChan:                   make(chan bool, 1),
+		defaults:                      map[defaultRole]string{},
 	}
 
 	// Enables audio clients to discover audio endpoint devices.
@@ -373,26 +395,11 @@ func New() (*CoreAudio, error) {
 		return nil, err
 	}
 
+	ca.Subscribe(updateSystrayVolume)
+
 	go ca.coreAudioEventLoop()
+	go ca.volumeEventLoop()
 	ca.refreshHardwareDevicesChannel <- true
 
 	return ca, nil
 }
-
-// clampValue is for taking the integer values from the MIDI device and clamping it to a given range.
-func clampValue(value, inputMin, inputMax int) int {
-	if value > inputMax {
-		return inputMax
-	}
-	if value < inputMin {
-		return inputMin
-	}
-	return value
-}
-
-// mapValue will take an input value along with input range and map it to an output range to allow
-// for nice things like limiting total range output, or reverse a range if desired.
-func mapValue(value, inputMin, inputMax int, outputMin, outputMax float32) float32 {
-	value = int(math.Max(float64(inputMin), math.Min(float64(inputMax), float64(value))))
-	return float32(value-inputMin)/float32(inputMax-inputMin)*float32(outputMax-outputMin) + float32(outputMin)
-}
@@ -0,0 +1,88 @@
+//go:build windows
+// +build windows
+
+package coreaudio
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	ole "github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+var (
+	// IPolicyConfig is undocumented, but it's what the Windows sound settings use to change the default devices.
+	// It's been the same since Windows 7.
+	clsidPolicyConfigClient = ole.NewGUID("{870AF99C-171D-4F9E-AF0D-E63DF40C2BC9}")
+	iidPolicyConfig         = ole.NewGUID("{F8679F50-850A-41CF-9C72-430F290290C8}")
+)
+
+type iPolicyConfig struct {
+	ole.IUnknown
+}
+
+type iPolicyConfigVtbl struct {
+	ole.IUnknownVtbl
+	GetMixFormat          uintptr
+	GetDeviceFormat       uintptr
+	ResetDeviceFormat     uintptr
+	SetDeviceFormat       uintptr
+	GetProcessingPeriod   uintptr
+	SetProcessingPeriod   uintptr
+	GetShareMode          uintptr
+	SetShareMode          uintptr
+	GetPropertyValue      uintptr
+	SetPropertyValue      uintptr
+	SetDefaultEndpoint    uintptr
+	SetEndpointVisibility uintptr
+}
+
+func (v *iPolicyConfig) VTable() *iPolicyConfigVtbl {
+	return (*iPolicyConfigVtbl)(unsafe.Pointer(v.RawVTable))
+}
+
+func (v *iPolicyConfig) SetDefaultEndpoint(id string, role wca.ERole) error {
+	wid, err := syscall.UTF16PtrFromString(id)
+	if err != nil {
+		return err
+	}
+	hr, _, _ := syscall.Syscall(
+		v.VTable().SetDefaultEndpoint,
+		3,
+		uintptr(unsafe.Pointer(v)),
+		uintptr(unsafe.Pointer(wid)),
+		uintptr(role))
+	if hr != 0 {
+		return ole.NewError(hr)
+	}
+	return nil
+}
+
+// setDefaultEndpoint makes the device with the endpoint id the default for each of the roles.
+func setDefaultEndpoint(id string, roles []wca.ERole) error {
+	unknown, err := ole.CreateInstance(clsidPolicyConfigClient, iidPolicyConfig)
+	if err != nil {
+		return err
+	}
+	pc := (*iPolicyConfig)(unsafe.Pointer(unknown))
+	defer pc.Release()
+
+	for _, role := range roles {
+		if err := pc.SetDefaultEndpoint(id, role); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDefaultDevice makes the device with the endpoint id the default of its flow for each of the roles. Windows
+// then lets us know the default changed, which is where everything else picks up the new device.
+func (ca *CoreAudio) SetDefaultDevice(id string, roles []audio.Role) error {
+	wroles := []wca.ERole{}
+	for _, role := range roles {
+		wroles = append(wroles, windowsRole(role))
+	}
+	return setDefaultEndpoint(id, wroles)
+}
@@ -1,17 +1,22 @@
+//go:build windows
+// +build windows
+
 package device
 
 import (
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unsafe"
 
 	"github.com/GregoryDosh/automidically/internal/coreaudio/audiosession"
 	"github.com/bep/debounce"
+	ole "github.com/go-ole/go-ole"
 	"github.com/moutend/go-wca/pkg/wca"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows"
 )
 
 var (
@@ -21,12 +26,36 @@ var (
 	AudioSessionNotFound       = errors.New("audio session not found")
 )
 
+// Flow is the direction audio goes through a device, out of the speakers or in from a microphone.
+type Flow uint32
+
+const (
+	Output Flow = wca.ERender
+	Input  Flow = wca.ECapture
+)
+
+func (f Flow) String() string {
+	switch f {
+	case Output:
+		return "output"
+	case Input:
+		return "input"
+	}
+	return "unknown"
+}
+
 type Device struct {
+	Flow                 Flow
 	mmd                  *wca.IMMDevice
 	aev                  *wca.IAudioEndpointVolume
 	audioSessions        []*audiosession.AudioSession
 	audioSessionManager2 *wca.IAudioSessionManager2
 	sessionNotification  *wca.IAudioSessionNotification
+	onSessionsRefreshed  func(*Device, []*audiosession.AudioSession)
+	events               *audioEndpointVolumeCallback
+	live                 LiveState
+	onVolumeChanged      func(*Device, bool)
+	liveLock             sync.Mutex
 	sync.Mutex
 }
 
@@ -46,6 +75,7 @@ func (d *Device) Cleanup() error {
 			return err
 		}
 	}
+	d.unregisterEvents()
 	if d.aev != nil {
 		d.aev.Release()
 		d.aev = nil
@@ -75,6 +105,31 @@ func (d *Device) DeviceName() (string, error) {
 	return pv.String(), nil
 }
 
+// ID returns the endpoint ID of the device, this is what Windows uses to tell devices apart in notifications.
+func (d *Device) ID() (string, error) {
+	if d.mmd == nil {
+		return "", UninitializedDeviceError
+	}
+	return EndpointID(d.mmd)
+}
+
+// EndpointID returns the endpoint ID of an IMMDevice.
+// IMMDevice.GetId from go-wca only has room for a 32-bit pointer, so the call is made here instead.
+func EndpointID(mmd *wca.IMMDevice) (string, error) {
+	var id *uint16
+	hr, _, _ := syscall.Syscall(
+		mmd.VTable().GetId,
+		2,
+		uintptr(unsafe.Pointer(mmd)),
+		uintptr(unsafe.Pointer(&id)),
+		0)
+	if hr != 0 {
+		return "", ole.NewError(hr)
+	}
+	defer ole.CoTaskMemFree(uintptr(unsafe.Pointer(id)))
+	return windows.UTF16PtrToString(id), nil
+}
+
 // GetVolumeLevel will get the volume of the device, if it exists, as a float on the scale of 0-1.
 func (d *Device) GetVolumeLevel() (float32, error) {
 	if d.mmd == nil {
@@ -88,6 +143,30 @@ func (d *Device) GetVolumeLevel() (float32, error) {
 	return v, nil
 }
 
+// GetMute will get the mute state of the device, if it exists.
+func (d *Device) GetMute() (bool, error) {
+	if d.mmd == nil {
+		return false, UninitializedDeviceError
+	}
+	var m bool
+	if err := d.aev.GetMute(&m); err != nil {
+		return false, err
+	}
+	return m, nil
+}
+
+// SetMute will mute or unmute the device.
+func (d *Device) SetMute(m bool) error {
+	if d.mmd == nil {
+		return UninitializedDeviceError
+	}
+	if err := d.aev.SetMute(m, eventContext); err != nil {
+		return err
+	}
+	d.setLiveMute(m)
+	return nil
+}
+
 // createDebouncedOnSessionCreateFunction is called when there is a new audio session created on this device.
 // This looks really weird because the onSessionCreated function can get called many times
 // in rapid succession so we want to debounce that function call. But the callback has to take
@@ -177,58 +256,67 @@ func (d *Device) RefreshAudioSessions() error {
 		log.Tracef("discovered audioSession %s", as.ProcessExecutable)
 	}
 
+	if d.onSessionsRefreshed != nil {
+		d.onSessionsRefreshed(d, d.audioSessions)
+	}
+
 	return nil
 }
 
+// OnAudioSessionsRefreshed sets a function to be called with all of the audio sessions each time they're refreshed.
+// The device is locked during the call, so fn can't call back into the device.
+func (d *Device) OnAudioSessionsRefreshed(fn func(*Device, []*audiosession.AudioSession)) {
+	d.Lock()
+	defer d.Unlock()
+	d.onSessionsRefreshed = fn
+}
+
+// AudioSessions returns the audio sessions found at the last refresh. They might get cleaned up by the next one,
+// after which they return audiosession.ErrorUninitializedAudioSession.
+func (d *Device) AudioSessions() []*audiosession.AudioSession {
+	d.Lock()
+	defer d.Unlock()
+	return append([]*audiosession.AudioSession{}, d.audioSessions...)
+}
+
 // SetVolumeLevel takes a float between 0-1 and it will set the volume of the device to that value.
 func (d *Device) SetVolumeLevel(v float32) error {
 	if (v < 0) || (1 < v) {
 		return fmt.Errorf("invalid volume level %f", v)
 	}
 
-	if err := d.aev.SetMasterVolumeLevelScalar(v, nil); err != nil {
+	if err := d.aev.SetMasterVolumeLevelScalar(v, eventContext); err != nil {
 		return err
 	}
-	return nil
-}
-
-// SetAudioSessionVolumeLevel takes the sessionName which is the string to match on the ProcessExecutable of the sessions and a float between 0-1 to set the volume of any matching sessions.
-func (d *Device) SetAudioSessionVolumeLevel(sessionName string, v float32) error {
-	d.Lock()
-	defer d.Unlock()
-	foundSession := false
-
-	for _, f := range d.audioSessions {
-		if strings.EqualFold(sessionName, f.ProcessExecutable) {
-			if err := f.SetVolumeLevel(v); err != nil {
-				if !errors.Is(err, audiosession.ErrorAudioSessionStateExpired) {
-					return err
-				}
-				continue
-			}
-			foundSession = true
-		}
-	}
-
-	if !foundSession {
-		return fmt.Errorf("%w: %s", AudioSessionNotFound, sessionName)
-	}
+	d.setLiveVolume(v)
 	return nil
 }
 
 // New takes in a *wca.IMMDevice and wraps it as a *Device with some nice helper methods to do common tasks like SetVolumeLevel, GetVolumeLevel, etc.
-func New(mmd *wca.IMMDevice) (*Device, error) {
+// The flow is whether the device is an output or input, since the IMMDevice doesn't readily say.
+func New(mmd *wca.IMMDevice, flow Flow) (*Device, error) {
 	if mmd == nil {
 		return nil, UninitializedDeviceError
 	}
 
 	d := &Device{
-		mmd: mmd,
+		Flow: flow,
+		mmd:  mmd,
 	}
 
 	if err := d.mmd.Activate(wca.IID_IAudioEndpointVolume, wca.CLSCTX_ALL, nil, &d.aev); err != nil {
 		return nil, MissingAudioEndpointVolume
 	}
 
+	// Keeping up with changes made outside of Automidically, like in the Windows volume mixer.
+	if v, err := d.GetVolumeLevel(); err == nil {
+		if m, err := d.GetMute(); err == nil {
+			d.live = LiveState{Volume: v, Mute: m, ok: true}
+		}
+	}
+	if err := d.registerEvents(); err != nil {
+		log.Errorf("unable to watch device for changes: %s", err)
+	}
+
 	return d, nil
 }
@@ -0,0 +1,150 @@
+//go:build windows
+// +build windows
+
+package device
+
+import (
+	"sync"
+	"syscall"
+	"unsafe"
+
+	ole "github.com/go-ole/go-ole"
+	"github.com/moutend/go-wca/pkg/wca"
+)
+
+var (
+	// eventContext is passed along with the changes made by Automidically, so they can be told apart from the
+	// changes made in the Windows volume mixer or by the keyboard volume keys.
+	eventContext = ole.NewGUID("{0B4E7C55-2D8A-4F0E-A7C3-1E9B6D2F8A30}")
+
+	endpointCallbackOnce sync.Once
+	endpointCallbackVtbl *audioEndpointVolumeCallbackVtbl
+)
+
+// LiveState is the volume and mute of a device as of the last change, ok is false if it isn't known.
+type LiveState struct {
+	Volume float32
+	Mute   bool
+	ok     bool
+}
+
+// audioVolumeNotificationData is AUDIO_VOLUME_NOTIFICATION_DATA, only the master volume is of use so the
+// per channel volumes that follow it are left out.
+type audioVolumeNotificationData struct {
+	EventContext ole.GUID
+	Muted        int32
+	MasterVolume float32
+	Channels     uint32
+}
+
+type audioEndpointVolumeCallbackVtbl struct {
+	QueryInterface uintptr
+	AddRef         uintptr
+	Release        uintptr
+	OnNotify       uintptr
+}
+
+// audioEndpointVolumeCallback is the IAudioEndpointVolumeCallback handed to Windows for a device. It starts with
+// the vtable like any COM object, and the device it belongs to follows where Windows won't look.
+type audioEndpointVolumeCallback struct {
+	vtbl   *audioEndpointVolumeCallbackVtbl
+	device *Device
+}
+
+// getEndpointCallbackVtbl creates the callbacks once for every device, Windows only allows so many of them.
+func getEndpointCallbackVtbl() *audioEndpointVolumeCallbackVtbl {
+	endpointCallbackOnce.Do(func() {
+		endpointCallbackVtbl = &audioEndpointVolumeCallbackVtbl{
+			QueryInterface: syscall.NewCallback(func(this *audioEndpointVolumeCallback, riid *ole.GUID, ppv *uintptr) uintptr {
+				if ole.IsEqualGUID(riid, ole.IID_IUnknown) || ole.IsEqualGUID(riid, wca.IID_IAudioEndpointVolumeCallback) {
+					*ppv = uintptr(unsafe.Pointer(this))
+					return ole.S_OK
+				}
+				*ppv = 0
+				return ole.E_NOINTERFACE
+			}),
+			AddRef: syscall.NewCallback(func(this *audioEndpointVolumeCallback) uintptr {
+				return 1
+			}),
+			Release: syscall.NewCallback(func(this *audioEndpointVolumeCallback) uintptr {
+				return 1
+			}),
+			OnNotify: syscall.NewCallback(func(this *audioEndpointVolumeCallback, data *audioVolumeNotificationData) uintptr {
+				d := this.device
+				d.liveLock.Lock()
+				d.live = LiveState{Volume: data.MasterVolume, Mute: data.Muted != 0, ok: true}
+				fn := d.onVolumeChanged
+				d.liveLock.Unlock()
+				if fn != nil {
+					fn(d, !ole.IsEqualGUID(&data.EventContext, eventContext))
+				}
+				return ole.S_OK
+			}),
+		}
+	})
+	return endpointCallbackVtbl
+}
+
+// registerEvents asks Windows to tell us about changes to the volume of the device. IAudioEndpointVolume's
+// RegisterControlChangeNotify from go-wca doesn't take the callback, so the call is made here instead.
+func (d *Device) registerEvents() error {
+	d.events = &audioEndpointVolumeCallback{
+		vtbl:   getEndpointCallbackVtbl(),
+		device: d,
+	}
+	hr, _, _ := syscall.Syscall(
+		d.aev.VTable().RegisterControlChangeNotify,
+		2,
+		uintptr(unsafe.Pointer(d.aev)),
+		uintptr(unsafe.Pointer(d.events)),
+		0)
+	if hr != 0 {
+		d.events = nil
+		return ole.NewError(hr)
+	}
+	return nil
+}
+
+// unregisterEvents stops the notifications, this expects the device to be locked already.
+func (d *Device) unregisterEvents() {
+	if d.events == nil || d.aev == nil {
+		return
+	}
+	hr, _, _ := syscall.Syscall(
+		d.aev.VTable().UnregisterControlChangeNotify,
+		2,
+		uintptr(unsafe.Pointer(d.aev)),
+		uintptr(unsafe.Pointer(d.events)),
+		0)
+	if hr != 0 {
+		log.Error(ole.NewError(hr))
+	}
+	d.events = nil
+}
+
+// OnVolumeChanged sets a function to be called when the volume or mute of the device changes. external is true
+// when the change wasn't made by Automidically. It's called from a Windows thread so it shouldn't block.
+func (d *Device) OnVolumeChanged(fn func(d *Device, external bool)) {
+	d.liveLock.Lock()
+	defer d.liveLock.Unlock()
+	d.onVolumeChanged = fn
+}
+
+// LiveState returns the volume & mute of the device without asking Windows, as of the last change.
+func (d *Device) LiveState() (volume float32, mute bool, ok bool) {
+	d.liveLock.Lock()
+	defer d.liveLock.Unlock()
+	return d.live.Volume, d.live.Mute, d.live.ok
+}
+
+func (d *Device) setLiveVolume(v float32) {
+	d.liveLock.Lock()
+	defer d.liveLock.Unlock()
+	d.live.Volume = v
+}
+
+func (d *Device) setLiveMute(m bool) {
+	d.liveLock.Lock()
+	defer d.liveLock.Unlock()
+	d.live.Mute = m
+}
@@ -0,0 +1,125 @@
+//go:build windows
+// +build windows
+
+package coreaudio
+
+import (
+	"sync"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/coreaudio/audiosession"
+	"github.com/GregoryDosh/automidically/internal/coreaudio/device"
+	"github.com/GregoryDosh/automidically/internal/systray"
+)
+
+// volumeEvent is handed over from the Windows threads the notifications arrive on, so they're never held up.
+type volumeEvent struct {
+	endpoint *endpoint
+	session  *audiosession.AudioSession
+	external bool
+}
+
+// volumeEventsBuffer is how many notifications can wait to be published, more than that are dropped.
+const volumeEventsBuffer = 100
+
+type subscribers struct {
+	fns []func(audio.VolumeChange)
+	sync.Mutex
+}
+
+// Subscribe calls fn with every VolumeChange from now on. The calls are made one at a time from the same
+// goroutine, so fn shouldn't take long.
+func (ca *CoreAudio) Subscribe(fn func(audio.VolumeChange)) {
+	ca.subscribers.Lock()
+	defer ca.subscribers.Unlock()
+	ca.subscribers.fns = append(ca.subscribers.fns, fn)
+}
+
+func (ca *CoreAudio) queueVolumeEvent(ev volumeEvent) {
+	select {
+	case ca.volumeEventsChannel <- ev:
+	default:
+		log.Debug("too many volume changes at once, dropping one")
+	}
+}
+
+// watchDevice has the volume changes of a device published. The endpoint has the name & id from when the device
+// was known to be good, since it might be cleaned up by the time the changes are published.
+func (ca *CoreAudio) watchDevice(e *endpoint) {
+	e.OnVolumeChanged(func(d *device.Device, external bool) {
+		ca.queueVolumeEvent(volumeEvent{endpoint: e, external: external})
+	})
+}
+
+// onAudioSessionsRefreshed has the volume changes of the audio sessions on a device published, and passes the
+// sessions along to the OnSessionsRefreshed function.
+func (ca *CoreAudio) onAudioSessionsRefreshed(e *endpoint, sessions []*audiosession.AudioSession) {
+	for _, as := range sessions {
+		as.OnVolumeChanged(func(as *audiosession.AudioSession, external bool) {
+			ca.queueVolumeEvent(volumeEvent{endpoint: e, session: as, external: external})
+		})
+	}
+
+	ca.callbacksLock.Lock()
+	fn := ca.onSessionsRefreshed
+	ca.callbacksLock.Unlock()
+	if fn != nil {
+		fn(e, wrapSessions(sessions))
+	}
+}
+
+// volumeEventLoop publishes the volume changes to the subscribers. The audio session notifications don't come
+// with a volume that can be read, so it's looked up here.
+func (ca *CoreAudio) volumeEventLoop() {
+	log.Trace("Enter volumeEventLoop")
+	defer log.Trace("Exit volumeEventLoop")
+
+	for {
+		var ev volumeEvent
+		select {
+		case <-ca.cleanupChan:
+			return
+		case ev = <-ca.volumeEventsChannel:
+		}
+
+		change := audio.VolumeChange{
+			DeviceID:   ev.endpoint.id,
+			DeviceName: ev.endpoint.name,
+			Flow:       ev.endpoint.Flow().String(),
+			Default:    ev.endpoint.IsDefault(audio.Console),
+			External:   ev.external,
+		}
+
+		var ok bool
+		if ev.session != nil {
+			if err := ev.session.RefreshLiveState(); err != nil {
+				log.Tracef("unable to get the state of %s: %s", ev.session.ProcessExecutable, err)
+				continue
+			}
+			change.Session = ev.session.ProcessExecutable
+			change.ProcessID = ev.session.ProcessID
+			change.Volume, change.Mute, ok = ev.session.LiveState()
+		} else {
+			change.Volume, change.Mute, ok = ev.endpoint.LiveState()
+		}
+		if !ok {
+			continue
+		}
+		log.Tracef("%+v", change)
+
+		ca.subscribers.Lock()
+		fns := ca.subscribers.fns
+		ca.subscribers.Unlock()
+		for _, fn := range fns {
+			fn(change)
+		}
+	}
+}
+
+// updateSystrayVolume shows the volume of the default output device in the systray tooltip.
+func updateSystrayVolume(change audio.VolumeChange) {
+	if change.Session != "" || !change.Default || change.Flow != audio.Output.String() {
+		return
+	}
+	systray.SetOutputVolume(change.DeviceName, change.Volume, change.Mute)
+}
@@ -0,0 +1,160 @@
+package feedback
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+	"github.com/GregoryDosh/automidically/internal/mixer"
+	"github.com/GregoryDosh/automidically/internal/pattern"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	log = logrus.WithField("module", "feedback")
+)
+
+const (
+	PropertyVolume = "volume"
+	PropertyMute   = "mute"
+)
+
+// Mapping sends the state of a volume target back to a MIDI device. The trigger describes the message
+// that gets sent, e.g. the cc of an LED or a motorized fader.
+type Mapping struct {
+	message.Trigger `yaml:",inline"`
+	Property        string      `yaml:"property"`
+	HardwareMin     int         `yaml:"hardwareMin"`
+	HardwareMax     int         `yaml:"hardwareMax"`
+	VolumeMin       float32     `yaml:"volumeMin"`
+	VolumeMax       float32     `yaml:"volumeMax"`
+	OnValue         int         `yaml:"onValue"`
+	OffValue        int         `yaml:"offValue"`
+	Curve           mixer.Curve `yaml:"curve"`
+	Filename        []string    `yaml:"-"`
+	Special         []string    `yaml:"-"`
+	Device          []string    `yaml:"-"`
+}
+
+func (m *Mapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	// This is so we can set some default values if not specified in the config.
+	type rawMapping Mapping
+	raw := rawMapping{
+		Trigger:     message.DefaultTrigger(),
+		Property:    PropertyVolume,
+		HardwareMin: 0,
+		VolumeMin:   0,
+		VolumeMax:   1,
+		OnValue:     127,
+		OffValue:    0,
+	}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	// The default hardwareMax depends on the type of message, e.g. pitch bend & high resolution cc are 14-bit.
+	rMax := struct {
+		HardwareMax *int `yaml:"hardwareMax"`
+	}{}
+	_ = unmarshal(&rMax)
+	if rMax.HardwareMax == nil {
+		raw.HardwareMax = raw.Trigger.MaxValue()
+	}
+
+	// Same as the mixer, infer the params as strings or slices.
+	{
+		rString := struct {
+			Filename string
+			Special  string
+			Device   string
+		}{}
+		_ = unmarshal(&rString)
+		if rString.Filename != "" {
+			raw.Filename = []string{rString.Filename}
+		}
+		if rString.Special != "" {
+			raw.Special = []string{rString.Special}
+		}
+		if rString.Device != "" {
+			raw.Device = []string{rString.Device}
+		}
+		rSlice := struct {
+			Filename []string
+			Special  []string
+			Device   []string
+		}{}
+		_ = unmarshal(&rSlice)
+		if len(rSlice.Filename) > 0 {
+			raw.Filename = rSlice.Filename
+		}
+		if len(rSlice.Special) > 0 {
+			raw.Special = rSlice.Special
+		}
+		if len(rSlice.Device) > 0 {
+			raw.Device = rSlice.Device
+		}
+	}
+
+	*m = Mapping(raw)
+	return nil
+}
+
+func (m *Mapping) Validate() error {
+	if !strings.EqualFold(m.Property, PropertyVolume) && !strings.EqualFold(m.Property, PropertyMute) {
+		return fmt.Errorf("feedback property %s should be %s or %s", m.Property, PropertyVolume, PropertyMute)
+	}
+	if err := m.Trigger.Validate(); err != nil {
+		return err
+	}
+	if err := pattern.Validate(m.Filename); err != nil {
+		return err
+	}
+	if err := pattern.Validate(m.Device); err != nil {
+		return err
+	}
+	if m.HardwareMin < 0 || m.HardwareMax > m.Trigger.MaxValue() {
+		return fmt.Errorf("hardware range [%d,%d] should be within [0,%d]", m.HardwareMin, m.HardwareMax, m.Trigger.MaxValue())
+	}
+	if m.OnValue < 0 || m.OnValue > m.Trigger.MaxValue() || m.OffValue < 0 || m.OffValue > m.Trigger.MaxValue() {
+		return fmt.Errorf("onValue %d and offValue %d should be within [0,%d]", m.OnValue, m.OffValue, m.Trigger.MaxValue())
+	}
+	if m.HardwareMin > m.HardwareMax {
+		return fmt.Errorf("hardware minimum %d should not be greater than maximum %d", m.HardwareMin, m.HardwareMax)
+	}
+	if m.VolumeMin < 0 || m.VolumeMin > 1 {
+		return fmt.Errorf("volume minimum %f should be in range [0,1]", m.VolumeMin)
+	}
+	if m.VolumeMax < 0 || m.VolumeMax > 1 {
+		return fmt.Errorf("volume maximum %f should be in range [0,1]", m.VolumeMax)
+	}
+	if err := m.Curve.Validate(); err != nil {
+		return err
+	}
+	if len(m.Filename)+len(m.Special)+len(m.Device) == 0 {
+		return fmt.Errorf("feedback for %s needs a filename, special, or device", m.Type)
+	}
+	return nil
+}
+
+// Message builds the MIDI message to send for the volume and mute state of the target.
+func (m *Mapping) Message(volume float32, mute bool) message.Message {
+	if strings.EqualFold(m.Property, PropertyMute) {
+		if mute {
+			return m.Trigger.Message(m.OnValue)
+		}
+		return m.Trigger.Message(m.OffValue)
+	}
+	return m.Trigger.Message(m.unmapValue(volume))
+}
+
+// unmapValue is the reverse of the mixer's mapping, taking a volume in the range [volumeMin, volumeMax]
+// back through the curve to the hardware range so faders end up where they'd need to be to set that volume.
+func (m *Mapping) unmapValue(volume float32) int {
+	if m.VolumeMin == m.VolumeMax {
+		return m.HardwareMin
+	}
+	ratio := float64((volume - m.VolumeMin) / (m.VolumeMax - m.VolumeMin))
+	ratio = m.Curve.Invert(math.Max(0, math.Min(1, ratio)))
+	return m.HardwareMin + int(math.Round(ratio*float64(m.HardwareMax-m.HardwareMin)))
+}
@@ -0,0 +1,56 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrorInvalidChannel = errors.New("MIDI channel should be in range [1,16]")
+
+// Channels is a set of MIDI channels a mapping listens to. An empty set matches any channel.
+type Channels []int
+
+// Contains reports if channel c is part of the set.
+func (cs Channels) Contains(c int) bool {
+	if len(cs) == 0 {
+		return true
+	}
+	for _, ch := range cs {
+		if ch == c {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalYAML accepts a single channel, a list of channels, or the string "any".
+func (cs *Channels) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil && strings.EqualFold(s, "any") {
+		*cs = nil
+		return nil
+	}
+
+	var single int
+	if err := unmarshal(&single); err == nil {
+		*cs = Channels{single}
+		return cs.validate()
+	}
+
+	var list []int
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("channel should be a number, list of numbers, or any: %w", err)
+	}
+	*cs = Channels(list)
+	return cs.validate()
+}
+
+func (cs Channels) validate() error {
+	for _, c := range cs {
+		if c < 1 || c > 16 {
+			return fmt.Errorf("%w: %d", ErrorInvalidChannel, c)
+		}
+	}
+	return nil
+}
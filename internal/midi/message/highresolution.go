@@ -0,0 +1,52 @@
+package message
+
+import (
+	"fmt"
+	"sync"
+)
+
+// HighResolutionTracker combines 14-bit control changes sent as a pair of messages, the MSB on cc n in [0,31]
+// followed by the LSB on cc n+32. The last MSB is remembered per device, channel, and cc.
+// It's safe to use with messages from multiple devices at once.
+type HighResolutionTracker struct {
+	msb map[string]int
+	sync.Mutex
+}
+
+func NewHighResolutionTracker() *HighResolutionTracker {
+	return &HighResolutionTracker{
+		msb: map[string]int{},
+	}
+}
+
+// Track looks at a message and if it's part of a 14-bit pair, returns the combined message with HighResolution set.
+// The Number of the combined message is always the MSB cc. A new MSB resets the LSB to 0 like the MIDI spec says,
+// so devices that only send the MSB still work, and the following LSB refines the value.
+func (h *HighResolutionTracker) Track(msg Message) (Message, bool) {
+	if msg.Kind != ControlChange || msg.HighResolution || msg.Number >= 64 {
+		return Message{}, false
+	}
+
+	h.Lock()
+	defer h.Unlock()
+
+	combined := msg
+	combined.HighResolution = true
+	if msg.Number < 32 {
+		h.msb[h.key(msg.Device, msg.Channel, msg.Number)] = msg.Value
+		combined.Value = msg.Value << 7
+		return combined, true
+	}
+
+	combined.Number = msg.Number - 32
+	msb, ok := h.msb[h.key(msg.Device, msg.Channel, combined.Number)]
+	if !ok {
+		return Message{}, false
+	}
+	combined.Value = msb<<7 | msg.Value
+	return combined, true
+}
+
+func (h *HighResolutionTracker) key(device string, channel, cc int) string {
+	return fmt.Sprintf("%s/%d/%d", device, channel, cc)
+}
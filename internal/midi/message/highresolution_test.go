@@ -0,0 +1,45 @@
+package message
+
+import (
+	"testing"
+)
+
+func TestHighResolutionTracker(t *testing.T) {
+	cc := func(number, value int) Message {
+		return Message{Kind: ControlChange, Channel: 1, Number: number, Value: value, Device: "a"}
+	}
+	tests := []struct {
+		name    string
+		msg     Message
+		tracked bool
+		expect  int
+	}{
+		{"lsb before any msb", cc(33, 10), false, 0},
+		{"msb", cc(1, 64), true, 64 << 7},
+		{"lsb", cc(33, 10), true, 64<<7 | 10},
+		{"new msb resets the lsb", cc(1, 65), true, 65 << 7},
+		{"lsb of the new msb", cc(33, 3), true, 65<<7 | 3},
+		{"msb only device", cc(2, 127), true, 127 << 7},
+		{"other device's lsb", Message{Kind: ControlChange, Channel: 1, Number: 34, Value: 1, Device: "b"}, false, 0},
+		{"other channel's lsb", Message{Kind: ControlChange, Channel: 2, Number: 33, Value: 1, Device: "a"}, false, 0},
+		{"cc above the pairs", cc(64, 127), false, 0},
+		{"not a cc", Message{Kind: NoteOn, Channel: 1, Number: 1, Value: 127, Device: "a"}, false, 0},
+	}
+	h := NewHighResolutionTracker()
+	for _, tt := range tests {
+		combined, ok := h.Track(tt.msg)
+		if ok != tt.tracked {
+			t.Errorf("%s: tracked %t, expected %t", tt.name, ok, tt.tracked)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !combined.HighResolution || combined.Value != tt.expect {
+			t.Errorf("%s: got %d high resolution %t, expected %d", tt.name, combined.Value, combined.HighResolution, tt.expect)
+		}
+		if combined.Number != tt.msg.Number%32 {
+			t.Errorf("%s: combined on cc %d, expected the msb cc %d", tt.name, combined.Number, tt.msg.Number%32)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorInvalidMessage = errors.New("invalid MIDI message")
+	ErrorUnknownKind    = errors.New("unknown MIDI message type")
+)
+
+// Kind is the type of a channel voice message, decoded from the upper nibble of the status byte.
+type Kind int
+
+const (
+	Unknown Kind = iota
+	NoteOff
+	NoteOn
+	PolyAftertouch
+	ControlChange
+	ProgramChange
+	ChannelPressure
+	PitchBend
+	// Note isn't sent by any device, it's used by mappings to match both NoteOn & NoteOff.
+	Note
+)
+
+var kindNames = map[Kind]string{
+	Unknown:         "unknown",
+	NoteOff:         "noteOff",
+	NoteOn:          "noteOn",
+	PolyAftertouch:  "polyAftertouch",
+	ControlChange:   "cc",
+	ProgramChange:   "programChange",
+	ChannelPressure: "channelPressure",
+	PitchBend:       "pitchBend",
+	Note:            "note",
+}
+
+func (k Kind) String() string {
+	if s, ok := kindNames[k]; ok {
+		return s
+	}
+	return kindNames[Unknown]
+}
+
+// MaxValue is the largest value a message of this kind can carry.
+func (k Kind) MaxValue() int {
+	if k == PitchBend {
+		return 16383
+	}
+	return 127
+}
+
+// Matches reports if a message of kind other satisfies k, this is only different from equality for Note.
+func (k Kind) Matches(other Kind) bool {
+	if k == Note {
+		return other == NoteOn || other == NoteOff
+	}
+	return k == other
+}
+
+// ParseKind converts the configuration name of a message type to a Kind. It's case insensitive.
+func ParseKind(s string) (Kind, error) {
+	switch strings.ToLower(s) {
+	case "cc", "controlchange", "control":
+		return ControlChange, nil
+	case "note":
+		return Note, nil
+	case "noteon":
+		return NoteOn, nil
+	case "noteoff":
+		return NoteOff, nil
+	case "polyaftertouch", "aftertouch":
+		return PolyAftertouch, nil
+	case "programchange", "program":
+		return ProgramChange, nil
+	case "channelpressure", "pressure":
+		return ChannelPressure, nil
+	case "pitchbend", "pitch":
+		return PitchBend, nil
+	}
+	return Unknown, fmt.Errorf("%w: %s", ErrorUnknownKind, s)
+}
+
+func (k *Kind) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := ParseKind(s)
+	if err != nil {
+		return err
+	}
+	*k = parsed
+	return nil
+}
+
+// Message is a decoded MIDI channel voice message.
+type Message struct {
+	Kind Kind
+	// Channel is in the range [1,16] like most hardware labels it.
+	Channel int
+	// Number is the controller for ControlChange, the note for NoteOn/NoteOff/PolyAftertouch,
+	// and the program for ProgramChange. It's 0 for kinds that don't address anything.
+	Number int
+	// Value is the data for the message: velocity, pressure, controller value, or the 14-bit pitch bend.
+	// ProgramChange has no data so the program is repeated here to allow value based mappings.
+	Value     int
+	Timestamp time.Time
+	// Device is the configured name of the MIDI device the message came from.
+	Device string
+	// HighResolution is set for a ControlChange combined from an MSB/LSB pair, the Value is then in the range [0,16383].
+	HighResolution bool
+}
+
+// Parse decodes the raw bytes received from a MIDI device. System messages and running status aren't supported.
+// A NoteOn with a velocity of 0 is reported as a NoteOff, and NoteOff always has a value of 0 so buttons
+// sending either convention look the same to the mappings.
+func Parse(data []byte, timestamp time.Time) (Message, error) {
+	if len(data) == 0 || data[0] < 0x80 || data[0] >= 0xF0 {
+		return Message{}, fmt.Errorf("%w: % X", ErrorInvalidMessage, data)
+	}
+
+	msg := Message{
+		Channel:   int(data[0]&0x0F) + 1,
+		Timestamp: timestamp,
+	}
+
+	length := 3
+	switch data[0] & 0xF0 {
+	case 0x80:
+		msg.Kind = NoteOff
+	case 0x90:
+		msg.Kind = NoteOn
+	case 0xA0:
+		msg.Kind = PolyAftertouch
+	case 0xB0:
+		msg.Kind = ControlChange
+	case 0xC0:
+		msg.Kind = ProgramChange
+		length = 2
+	case 0xD0:
+		msg.Kind = ChannelPressure
+		length = 2
+	case 0xE0:
+		msg.Kind = PitchBend
+	}
+	if len(data) < length {
+		return Message{}, fmt.Errorf("%w: % X", ErrorInvalidMessage, data)
+	}
+
+	switch msg.Kind {
+	case ProgramChange:
+		msg.Number = int(data[1])
+		msg.Value = int(data[1])
+	case ChannelPressure:
+		msg.Value = int(data[1])
+	case PitchBend:
+		msg.Value = int(data[2])<<7 | int(data[1])
+	default:
+		msg.Number = int(data[1])
+		msg.Value = int(data[2])
+	}
+
+	if msg.Kind == NoteOn && msg.Value == 0 {
+		msg.Kind = NoteOff
+	}
+	if msg.Kind == NoteOff {
+		msg.Value = 0
+	}
+
+	return msg, nil
+}
+
+// Encode is the opposite of Parse and turns a message into the raw bytes to send to a MIDI device.
+// Each of the returned messages has to be written by itself, a HighResolution ControlChange is encoded as
+// the MSB message followed by the LSB message since the outputs only take one channel message per write.
+func Encode(msg Message) ([][]byte, error) {
+	if msg.Channel < 1 || msg.Channel > 16 {
+		return nil, fmt.Errorf("%w: %d", ErrorInvalidChannel, msg.Channel)
+	}
+	channel := byte(msg.Channel - 1)
+	number := byte(msg.Number & 0x7F)
+	value := byte(msg.Value & 0x7F)
+
+	switch msg.Kind {
+	case NoteOff:
+		return [][]byte{{0x80 | channel, number, value}}, nil
+	case NoteOn, Note:
+		return [][]byte{{0x90 | channel, number, value}}, nil
+	case PolyAftertouch:
+		return [][]byte{{0xA0 | channel, number, value}}, nil
+	case ControlChange:
+		if msg.HighResolution {
+			return [][]byte{
+				{0xB0 | channel, number, byte((msg.Value >> 7) & 0x7F)},
+				{0xB0 | channel, number + 32, value},
+			}, nil
+		}
+		return [][]byte{{0xB0 | channel, number, value}}, nil
+	case ProgramChange:
+		return [][]byte{{0xC0 | channel, number}}, nil
+	case ChannelPressure:
+		return [][]byte{{0xD0 | channel, value}}, nil
+	case PitchBend:
+		return [][]byte{{0xE0 | channel, byte(msg.Value & 0x7F), byte((msg.Value >> 7) & 0x7F)}}, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrorUnknownKind, msg.Kind)
+}
@@ -0,0 +1,138 @@
+package message
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		data   []byte
+		expect Message
+	}{
+		{"note on", []byte{0x90, 60, 100}, Message{Kind: NoteOn, Channel: 1, Number: 60, Value: 100}},
+		{"note on without velocity", []byte{0x93, 60, 0}, Message{Kind: NoteOff, Channel: 4, Number: 60}},
+		{"note off with velocity", []byte{0x80, 60, 64}, Message{Kind: NoteOff, Channel: 1, Number: 60}},
+		{"poly aftertouch", []byte{0xA1, 61, 20}, Message{Kind: PolyAftertouch, Channel: 2, Number: 61, Value: 20}},
+		{"cc", []byte{0xBF, 7, 127}, Message{Kind: ControlChange, Channel: 16, Number: 7, Value: 127}},
+		{"program change", []byte{0xC0, 5}, Message{Kind: ProgramChange, Channel: 1, Number: 5, Value: 5}},
+		{"channel pressure", []byte{0xD2, 90}, Message{Kind: ChannelPressure, Channel: 3, Value: 90}},
+		{"pitch bend", []byte{0xE0, 0x7F, 0x7F}, Message{Kind: PitchBend, Channel: 1, Value: 16383}},
+		{"pitch bend middle", []byte{0xE0, 0x00, 0x40}, Message{Kind: PitchBend, Channel: 1, Value: 8192}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := Parse(tt.data, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.expect.Timestamp = now
+			if msg != tt.expect {
+				t.Errorf("got %+v, expected %+v", msg, tt.expect)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"data byte", []byte{0x40, 1, 2}},
+		{"system", []byte{0xF8}},
+		{"short cc", []byte{0xB0, 7}},
+		{"short program change", []byte{0xC0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.data, time.Now()); !errors.Is(err, ErrorInvalidMessage) {
+				t.Errorf("expected %s, got %v", ErrorInvalidMessage, err)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    Message
+		expect [][]byte
+	}{
+		{"note on", Message{Kind: NoteOn, Channel: 1, Number: 60, Value: 100}, [][]byte{{0x90, 60, 100}}},
+		{"note", Message{Kind: Note, Channel: 2, Number: 60, Value: 127}, [][]byte{{0x91, 60, 127}}},
+		{"note off", Message{Kind: NoteOff, Channel: 16, Number: 60}, [][]byte{{0x8F, 60, 0}}},
+		{"cc", Message{Kind: ControlChange, Channel: 1, Number: 7, Value: 64}, [][]byte{{0xB0, 7, 64}}},
+		{"high resolution cc", Message{Kind: ControlChange, Channel: 1, Number: 7, Value: 64<<7 | 5, HighResolution: true},
+			[][]byte{{0xB0, 7, 64}, {0xB0, 39, 5}}},
+		{"program change", Message{Kind: ProgramChange, Channel: 1, Number: 5}, [][]byte{{0xC0, 5}}},
+		{"channel pressure", Message{Kind: ChannelPressure, Channel: 1, Value: 90}, [][]byte{{0xD0, 90}}},
+		{"pitch bend", Message{Kind: PitchBend, Channel: 1, Value: 8192}, [][]byte{{0xE0, 0x00, 0x40}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Encode(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(data, tt.expect) {
+				t.Errorf("got % X, expected % X", data, tt.expect)
+			}
+		})
+	}
+
+	if _, err := Encode(Message{Kind: ControlChange, Channel: 17}); !errors.Is(err, ErrorInvalidChannel) {
+		t.Errorf("expected %s, got %v", ErrorInvalidChannel, err)
+	}
+	if _, err := Encode(Message{Kind: Unknown, Channel: 1}); !errors.Is(err, ErrorUnknownKind) {
+		t.Errorf("expected %s, got %v", ErrorUnknownKind, err)
+	}
+}
+
+// Everything a device can send should come back the same after being encoded & parsed again.
+func TestEncodeParse(t *testing.T) {
+	for _, msg := range []Message{
+		{Kind: NoteOn, Channel: 10, Number: 36, Value: 1},
+		{Kind: NoteOff, Channel: 1, Number: 127},
+		{Kind: PolyAftertouch, Channel: 5, Number: 0, Value: 127},
+		{Kind: ControlChange, Channel: 16, Number: 64, Value: 0},
+		{Kind: ProgramChange, Channel: 1, Number: 99, Value: 99},
+		{Kind: ChannelPressure, Channel: 7, Value: 33},
+		{Kind: PitchBend, Channel: 2, Value: 1234},
+	} {
+		data, err := Encode(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := Parse(data[0], msg.Timestamp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != msg {
+			t.Errorf("got %+v back, expected %+v", parsed, msg)
+		}
+	}
+}
+
+func TestParseKind(t *testing.T) {
+	for s, expect := range map[string]Kind{
+		"cc":            ControlChange,
+		"ControlChange": ControlChange,
+		"note":          Note,
+		"NOTEON":        NoteOn,
+		"program":       ProgramChange,
+		"pitchBend":     PitchBend,
+	} {
+		if k, err := ParseKind(s); err != nil || k != expect {
+			t.Errorf("ParseKind(%s) = %s %v, expected %s", s, k, err, expect)
+		}
+	}
+	if _, err := ParseKind("sysex"); !errors.Is(err, ErrorUnknownKind) {
+		t.Errorf("expected %s, got %v", ErrorUnknownKind, err)
+	}
+}
@@ -0,0 +1,90 @@
+package message
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Trigger is shared by the different mapping types to describe which messages they respond to.
+// It's meant to be inlined into the mapping's yaml.
+type Trigger struct {
+	Type    Kind `yaml:"type"`
+	Cc      int  `yaml:"cc"`
+	Note    int  `yaml:"note"`
+	Program int  `yaml:"program"`
+	// Channel limits the trigger to messages on these channels, leaving it empty allows any channel.
+	Channel Channels `yaml:"channel"`
+	// MIDIDevice limits the trigger to messages from the named MIDI device, leaving it empty allows any device.
+	MIDIDevice string `yaml:"midiDevice"`
+	// HighResolution combines cc n in [0,31] and cc n+32 into a single 14-bit value.
+	HighResolution bool `yaml:"highResolution"`
+}
+
+// DefaultTrigger is what a mapping gets if nothing is specified, which keeps older configs with only a cc working.
+func DefaultTrigger() Trigger {
+	return Trigger{Type: ControlChange}
+}
+
+// MaxValue is the largest value messages matching this trigger can carry.
+func (t *Trigger) MaxValue() int {
+	if t.HighResolution {
+		return 16383
+	}
+	return t.Type.MaxValue()
+}
+
+// Validate makes sure the trigger describes messages that can actually be sent by a device.
+func (t *Trigger) Validate() error {
+	if t.HighResolution && (t.Type != ControlChange || t.Cc < 0 || t.Cc >= 32) {
+		return fmt.Errorf("highResolution is only supported for cc in range [0,31]")
+	}
+	if t.Cc < 0 || t.Cc > 127 || t.Note < 0 || t.Note > 127 || t.Program < 0 || t.Program > 127 {
+		return fmt.Errorf("cc, note, and program should be in range [0,127]")
+	}
+	return nil
+}
+
+// Matches reports if the message should be handled by the mapping owning this trigger.
+func (t *Trigger) Matches(msg Message) bool {
+	if !t.Type.Matches(msg.Kind) || !t.Channel.Contains(msg.Channel) || t.HighResolution != msg.HighResolution {
+		return false
+	}
+	if t.MIDIDevice != "" && !strings.EqualFold(t.MIDIDevice, msg.Device) {
+		return false
+	}
+	switch msg.Kind {
+	case ControlChange:
+		return t.Cc == msg.Number
+	case NoteOn, NoteOff, PolyAftertouch:
+		return t.Note == msg.Number
+	case ProgramChange:
+		return t.Program == msg.Number
+	}
+	return true
+}
+
+// Message builds a message this trigger would match, with the given value. This is used to send
+// feedback to a device using the same parameters as the mappings. The first channel is used, or 1 if any.
+func (t *Trigger) Message(value int) Message {
+	msg := Message{
+		Kind:           t.Type,
+		Channel:        1,
+		Value:          value,
+		HighResolution: t.HighResolution,
+	}
+	if len(t.Channel) > 0 {
+		msg.Channel = t.Channel[0]
+	}
+	switch t.Type {
+	case ControlChange:
+		msg.Number = t.Cc
+	case Note:
+		msg.Kind = NoteOn
+		msg.Number = t.Note
+	case NoteOn, NoteOff, PolyAftertouch:
+		msg.Number = t.Note
+	case ProgramChange:
+		msg.Number = t.Program
+	}
+	return msg
+}
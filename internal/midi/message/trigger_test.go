@@ -0,0 +1,91 @@
+package message
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func triggerFromYAML(t *testing.T, s string) Trigger {
+	t.Helper()
+	trigger := DefaultTrigger()
+	if err := yaml.Unmarshal([]byte(s), &trigger); err != nil {
+		t.Fatal(err)
+	}
+	return trigger
+}
+
+func TestTriggerMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger string
+		msg     Message
+		matches bool
+	}{
+		{"cc", "cc: 7", Message{Kind: ControlChange, Channel: 3, Number: 7}, true},
+		{"other cc", "cc: 7", Message{Kind: ControlChange, Channel: 1, Number: 8}, false},
+		{"channel", "{cc: 7, channel: 2}", Message{Kind: ControlChange, Channel: 1, Number: 7}, false},
+		{"channels", "{cc: 7, channel: [1, 2]}", Message{Kind: ControlChange, Channel: 2, Number: 7}, true},
+		{"any channel", "{cc: 7, channel: any}", Message{Kind: ControlChange, Channel: 16, Number: 7}, true},
+		{"note matches on", "{type: note, note: 60}", Message{Kind: NoteOn, Channel: 1, Number: 60}, true},
+		{"note matches off", "{type: note, note: 60}", Message{Kind: NoteOff, Channel: 1, Number: 60}, true},
+		{"note on doesn't match off", "{type: noteOn, note: 60}", Message{Kind: NoteOff, Channel: 1, Number: 60}, false},
+		{"program", "{type: programChange, program: 4}", Message{Kind: ProgramChange, Channel: 1, Number: 4}, true},
+		{"pitch bend", "{type: pitchBend}", Message{Kind: PitchBend, Channel: 1, Value: 100}, true},
+		{"device", "{cc: 7, midiDevice: Nano}", Message{Kind: ControlChange, Channel: 1, Number: 7, Device: "nano"}, true},
+		{"other device", "{cc: 7, midiDevice: Nano}", Message{Kind: ControlChange, Channel: 1, Number: 7, Device: "x"}, false},
+		{"high resolution", "{cc: 1, highResolution: true}", Message{Kind: ControlChange, Channel: 1, Number: 1, HighResolution: true}, true},
+		{"half of high resolution", "{cc: 1, highResolution: true}", Message{Kind: ControlChange, Channel: 1, Number: 1}, false},
+		{"combined for a 7-bit cc", "cc: 1", Message{Kind: ControlChange, Channel: 1, Number: 1, HighResolution: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trigger := triggerFromYAML(t, tt.trigger)
+			if m := trigger.Matches(tt.msg); m != tt.matches {
+				t.Errorf("Matches() = %t, expected %t", m, tt.matches)
+			}
+		})
+	}
+}
+
+func TestTriggerValidate(t *testing.T) {
+	tests := []struct {
+		trigger string
+		valid   bool
+	}{
+		{"cc: 127", true},
+		{"cc: 128", false},
+		{"{type: note, note: -1}", false},
+		{"{cc: 31, highResolution: true}", true},
+		{"{cc: 32, highResolution: true}", false},
+		{"{type: note, highResolution: true}", false},
+	}
+	for _, tt := range tests {
+		trigger := triggerFromYAML(t, tt.trigger)
+		if err := trigger.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: Validate() = %v, expected valid %t", tt.trigger, err, tt.valid)
+		}
+	}
+}
+
+// A message built by a trigger should always be matched by it, other than for Note which is sent as a NoteOn.
+func TestTriggerMessage(t *testing.T) {
+	for _, s := range []string{
+		"cc: 7",
+		"{cc: 7, channel: [3, 4]}",
+		"{type: note, note: 60}",
+		"{type: programChange, program: 2}",
+		"{cc: 1, highResolution: true}",
+		"{type: pitchBend, channel: 2}",
+	} {
+		trigger := triggerFromYAML(t, s)
+		msg := trigger.Message(100)
+		if !trigger.Matches(msg) || msg.Value != 100 {
+			t.Errorf("%s: built %+v which it doesn't match", s, msg)
+		}
+	}
+	note := triggerFromYAML(t, "{type: note, note: 60}")
+	if msg := note.Message(127); msg.Kind != NoteOn {
+		t.Errorf("note should be sent as a note on, got %s", msg.Kind)
+	}
+}
@@ -1,37 +1,61 @@
 package midi
 
 import (
+	"errors"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/GregoryDosh/automidically/internal/midi/message"
 	"github.com/sirupsen/logrus"
 	gomidi "gitlab.com/gomidi/midi"
 	driver "gitlab.com/gomidi/rtmididrv"
 )
 
-var log = logrus.WithField("module", "midi")
+var (
+	log                 = logrus.WithField("module", "midi")
+	ErrorNoOutputDevice = errors.New("MIDI device has no output connected")
+)
+
+// Status is whether a Device is connected, it's passed to the status callback given to New.
+type Status int
+
+const (
+	StatusConnected Status = iota
+	StatusDisconnected
+	// StatusRemoved is sent once when the Device is cleaned up.
+	StatusRemoved
+)
+
+// pollInterval is how often the MIDI inputs are checked to see if the device was unplugged or has come back.
+const pollInterval = time.Second * 2
 
 type Device struct {
+	// Name is the name given to the device in the config, this is attached to each message from the device.
+	Name string
+	// DeviceName is the name of the MIDI port in use, it's empty while disconnected.
 	DeviceName string
 
-	messageCallback func(int, int)
-	messageChan     chan [2]int
+	searchName      string
+	reportedMissing bool
+	messageCallback func(message.Message)
+	statusCallback  func(name string, status Status)
+	messageChan     chan message.Message
+	cleanupChan     chan bool
 	driver          *driver.Driver
 	device          gomidi.In
+	output          gomidi.Out
 	sync.Mutex
 }
 
 func (d *Device) Cleanup() error {
 	d.Lock()
 	defer d.Unlock()
-	if d.messageChan != nil {
-		close(d.messageChan)
-		d.messageChan = nil
-	}
-	if d.device != nil {
-		d.device.Close()
-		d.device = nil
+	if d.cleanupChan != nil {
+		close(d.cleanupChan)
+		d.cleanupChan = nil
 	}
+	d.disconnect()
 	if d.driver != nil {
 		d.driver.Close()
 		d.driver = nil
@@ -39,41 +63,219 @@ func (d *Device) Cleanup() error {
 	if d.messageCallback != nil {
 		d.messageCallback = nil
 	}
+	d.setStatus(StatusRemoved)
 	return nil
 }
 
-func (d *Device) SetMessageCallback(cb func(int, int)) {
+// Connected reports if the MIDI device is currently attached and listening.
+func (d *Device) Connected() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.device != nil
+}
+
+func (d *Device) SetMessageCallback(cb func(message.Message)) {
 	d.Lock()
 	defer d.Unlock()
 	d.messageCallback = cb
 }
 
+// setStatus tells the status callback about the connection, this expects the device to be locked already.
+func (d *Device) setStatus(status Status) {
+	if d.statusCallback != nil {
+		d.statusCallback(d.Name, status)
+	}
+}
+
+// connect opens the MIDI input and attaches the listener, this expects the device to be locked already.
+func (d *Device) connect(in gomidi.In) {
+	if err := in.Open(); err != nil {
+		log.Errorf("unable to open MIDI device %s: %s", in.String(), err)
+		return
+	}
+
+	if err := in.SetListener(d.listener); err != nil {
+		log.Errorf("unable to listen to MIDI device %s: %s", in.String(), err)
+		if err := in.Close(); err != nil {
+			log.Debug(err)
+		}
+		return
+	}
+
+	d.device = in
+	d.DeviceName = in.String()
+	d.reportedMissing = false
+	log.Infof("using MIDI device %s", d.DeviceName)
+	d.connectOutput()
+	d.setStatus(StatusConnected)
+}
+
+// connectOutput looks for an output port matching the device to send feedback to. Not all devices have one
+// so it's fine if nothing is found. This expects the device to be locked already.
+func (d *Device) connectOutput() {
+	midiOutputs, err := d.driver.Outs()
+	if err != nil {
+		log.Debugf("unable to open midi outputs: %s", err)
+		return
+	}
+	for _, out := range midiOutputs {
+		if !strings.Contains(strings.ToLower(out.String()), strings.ToLower(d.searchName)) {
+			continue
+		}
+		if err := out.Open(); err != nil {
+			log.Errorf("unable to open MIDI output %s: %s", out.String(), err)
+			return
+		}
+		log.Infof("using MIDI output %s", out.String())
+		d.output = out
+		return
+	}
+	log.Debugf("no MIDI output found containing '%s'", d.searchName)
+}
+
+// Send writes a message to the output of the device, e.g. to light up LEDs or move motorized faders.
+func (d *Device) Send(msg message.Message) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.output == nil {
+		return ErrorNoOutputDevice
+	}
+	packets, err := message.Encode(msg)
+	if err != nil {
+		return err
+	}
+	for _, data := range packets {
+		if _, err := d.output.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disconnect closes the MIDI input & output if they're open, this expects the device to be locked already.
+func (d *Device) disconnect() {
+	if d.output != nil {
+		if err := d.output.Close(); err != nil {
+			log.Debug(err)
+		}
+		d.output = nil
+	}
+	if d.device == nil {
+		return
+	}
+	if err := d.device.StopListening(); err != nil {
+		log.Debug(err)
+	}
+	if err := d.device.Close(); err != nil {
+		log.Debug(err)
+	}
+	d.device = nil
+	d.DeviceName = ""
+}
+
+// listener is called by the driver for every message received, so it shouldn't block.
+func (d *Device) listener(data []byte, deltaMicroseconds int64) {
+	msg, err := message.Parse(data, time.Now())
+	if err != nil {
+		log.Trace(err)
+		return
+	}
+	msg.Device = d.Name
+	select {
+	case d.messageChan <- msg:
+	default:
+		log.Warnf("dropping MIDI message from %s, too many queued", d.Name)
+	}
+}
+
 func (d *Device) handleMIDIMessageLoop() {
 	log.Trace("Enter handleMIDIMessageLoop")
 	defer log.Trace("Exit handleMIDIMessageLoop")
 
-	if err := d.device.Open(); err != nil {
-		log.Error(err)
+	cleanupChan := d.cleanupChan
+	for {
+		select {
+		case <-cleanupChan:
+			return
+		case msg := <-d.messageChan:
+			// Not holding the lock during the callback so a config reload can replace it without deadlocking.
+			d.Lock()
+			cb := d.messageCallback
+			d.Unlock()
+			if cb != nil {
+				cb(msg)
+			}
+		}
 	}
+}
 
-	if err := d.device.SetListener(func(data []byte, deltaMicroseconds int64) {
-		if len(data) == 3 {
-			d.messageChan <- [2]int{int(data[1]), int(data[2])}
+// supervisorLoop periodically polls the MIDI inputs to notice when the device is unplugged or plugged back in.
+func (d *Device) supervisorLoop() {
+	log.Trace("Enter supervisorLoop")
+	defer log.Trace("Exit supervisorLoop")
+
+	cleanupChan := d.cleanupChan
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cleanupChan:
+			return
+		case <-ticker.C:
+			d.poll()
 		}
-	}); err != nil {
-		log.Error(err)
+	}
+}
+
+// poll checks the current MIDI inputs against the device. If connected it makes sure the port still exists,
+// otherwise it looks for a matching port to connect to.
+func (d *Device) poll() {
+	d.Lock()
+	defer d.Unlock()
+	if d.driver == nil {
+		return
 	}
 
-	for msg := range d.messageChan {
-		d.Lock()
-		if d.messageCallback != nil {
-			d.messageCallback(msg[0], msg[1])
+	midiInputs, err := d.driver.Ins()
+	if err != nil {
+		log.Debugf("unable to open midi inputs: %s", err)
+		return
+	}
+
+	if d.device != nil {
+		for _, in := range midiInputs {
+			if in.String() == d.DeviceName {
+				return
+			}
+		}
+		log.Warnf("MIDI device %s disconnected", d.DeviceName)
+		d.disconnect()
+		d.setStatus(StatusDisconnected)
+		return
+	}
+
+	for _, in := range midiInputs {
+		if !d.reportedMissing {
+			log.Debugf("found device named '%s'", in.String())
+		}
+		if strings.Contains(strings.ToLower(in.String()), strings.ToLower(d.searchName)) {
+			d.connect(in)
+			return
 		}
-		d.Unlock()
+	}
+
+	if !d.reportedMissing {
+		log.Warnf("unable to find MIDI device containing '%s', waiting for it to connect", d.searchName)
+		d.setStatus(StatusDisconnected)
+		d.reportedMissing = true
 	}
 }
 
-func New(searchName string) *Device {
+// New will search the MIDI inputs for one containing searchName and start listening to it.
+// The name is how mappings refer to this device, and can be the same as the searchName.
+// If the device isn't available it will keep checking in the background and connect once it shows up.
+// The statusCallback, if there is one, is called whenever the device connects or disconnects.
+func New(name string, searchName string, statusCallback func(name string, status Status)) *Device {
 	if searchName == "" {
 		log.Error("missing MIDI device name")
 		return nil
@@ -85,28 +287,17 @@ func New(searchName string) *Device {
 		return nil
 	}
 
-	midiInputs, err := drv.Ins()
-	if err != nil {
-		log.Errorf("unable to open midi inputs: %s", err)
-		return nil
+	d := &Device{
+		Name:           name,
+		searchName:     searchName,
+		driver:         drv,
+		messageChan:    make(chan message.Message, 250),
+		cleanupChan:    make(chan bool),
+		statusCallback: statusCallback,
 	}
+	d.poll()
 
-	for _, in := range midiInputs {
-		log.Debugf("found device named '%s'", in.String())
-		if strings.Contains(strings.ToLower(in.String()), strings.ToLower(searchName)) {
-			log.Infof("using MIDI device %s", in.String())
-			d := &Device{
-				DeviceName:  in.String(),
-				driver:      drv,
-				device:      in,
-				messageChan: make(chan [2]int, 250),
-			}
-			go d.handleMIDIMessageLoop()
-			return d
-		}
-	}
-
-	log.Errorf("unable to find MIDI device containing '%s'", searchName)
-	drv.Close()
-	return nil
+	go d.handleMIDIMessageLoop()
+	go d.supervisorLoop()
+	return d
 }
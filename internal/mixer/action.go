@@ -0,0 +1,90 @@
+package mixer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Actions are what a mapping does to its targets. Anything other than volume is meant for buttons, where a
+// value at or above the threshold is pressed and below it is released.
+const (
+	ActionVolume     = "volume"
+	ActionMute       = "mute"
+	ActionUnmute     = "unmute"
+	ActionSetMute    = "setMute"
+	ActionToggleMute = "toggleMute"
+	ActionHoldMute   = "holdMute"
+	// ActionDefaultDevice makes the device the Windows default when pressed, with more than one device it cycles.
+	ActionDefaultDevice = "defaultDevice"
+)
+
+// Roles are the kinds of default device Windows keeps track of. The Windows sound settings set console & multimedia
+// together for the default device, and communications by itself for the default communications device.
+const (
+	RoleConsole        = "console"
+	RoleMultimedia     = "multimedia"
+	RoleCommunications = "communications"
+)
+
+const defaultThreshold = 64
+
+func defaultRoles() []string {
+	return []string{RoleConsole, RoleMultimedia}
+}
+
+func validateAction(action string) error {
+	for _, a := range []string{ActionVolume, ActionMute, ActionUnmute, ActionSetMute, ActionToggleMute, ActionHoldMute, ActionDefaultDevice} {
+		if strings.EqualFold(action, a) {
+			return nil
+		}
+	}
+	return fmt.Errorf("action %s should be one of %s, %s, %s, %s, %s, %s, or %s", action, ActionVolume, ActionMute, ActionUnmute, ActionSetMute, ActionToggleMute, ActionHoldMute, ActionDefaultDevice)
+}
+
+func validateRoles(roles []string) error {
+	for _, r := range roles {
+		if !strings.EqualFold(r, RoleConsole) && !strings.EqualFold(r, RoleMultimedia) && !strings.EqualFold(r, RoleCommunications) {
+			return fmt.Errorf("role %s should be one of %s, %s, or %s", r, RoleConsole, RoleMultimedia, RoleCommunications)
+		}
+	}
+	return nil
+}
+
+// IsMuteAction reports if the mapping changes the mute state of its targets instead of the volume.
+func (m *Mapping) IsMuteAction() bool {
+	return !strings.EqualFold(m.Action, ActionVolume) && !m.IsDefaultDeviceAction()
+}
+
+// IsDefaultDeviceAction reports if the mapping switches the default device instead of changing its targets.
+func (m *Mapping) IsDefaultDeviceAction() bool {
+	return strings.EqualFold(m.Action, ActionDefaultDevice)
+}
+
+// IsToggleMuteAction reports if the mapping flips the mute state of its targets, which is decided for all of them together.
+func (m *Mapping) IsToggleMuteAction() bool {
+	return strings.EqualFold(m.Action, ActionToggleMute)
+}
+
+// Pressed reports if the value sent by the MIDI device is a button being pressed.
+func (m *Mapping) Pressed(value int) bool {
+	return value >= m.Threshold
+}
+
+// MuteState takes the value sent by the MIDI device and the current mute state of a target, and returns
+// what the mute state should become. If the target shouldn't be changed then ok is false.
+// For toggleMute current should be if all of the targets are muted, so they all end up the same way.
+func (m *Mapping) MuteState(value int, current bool) (mute bool, ok bool) {
+	pressed := m.Pressed(value)
+	switch {
+	case strings.EqualFold(m.Action, ActionMute):
+		return true, pressed
+	case strings.EqualFold(m.Action, ActionUnmute):
+		return false, pressed
+	case strings.EqualFold(m.Action, ActionSetMute), strings.EqualFold(m.Action, ActionHoldMute):
+		// These only differ in intent, a latching button for setMute and a momentary one for holdMute.
+		return pressed, true
+	case strings.EqualFold(m.Action, ActionToggleMute):
+		return !current, pressed
+	}
+	return current, false
+}
@@ -0,0 +1,156 @@
+package mixer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	CurveLinear      = "linear"
+	CurveLogarithmic = "logarithmic"
+	CurveExponential = "exponential"
+	CurveSCurve      = "sCurve"
+	CurveDB          = "db"
+	CurveTable       = "table"
+)
+
+const (
+	defaultCurveExponent = 2
+	defaultCurveMinDB    = -60
+)
+
+// Curve shapes how the position of a fader is turned into a volume. It works on the normalized position in the
+// range [0,1] and returns a normalized volume in the range [0,1], before it's mapped to [volumeMin, volumeMax].
+type Curve struct {
+	Type     string       `yaml:"type"`
+	Exponent float64      `yaml:"exponent"`
+	MinDB    float64      `yaml:"minDB"`
+	Points   [][2]float64 `yaml:"points"`
+}
+
+// UnmarshalYAML accepts just the name of a curve, or a table with the type and any parameters.
+func (c *Curve) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawCurve Curve
+	raw := rawCurve{
+		Exponent: defaultCurveExponent,
+		MinDB:    defaultCurveMinDB,
+	}
+
+	var name string
+	if err := unmarshal(&name); err == nil {
+		raw.Type = name
+		*c = Curve(raw)
+		return nil
+	}
+
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	// Sorting here so the lookup can assume it, and so reordering the config isn't seen as a change.
+	sort.Slice(raw.Points, func(i, j int) bool {
+		return raw.Points[i][0] < raw.Points[j][0]
+	})
+	*c = Curve(raw)
+	return nil
+}
+
+func (c *Curve) Validate() error {
+	switch {
+	case c.Type == "", strings.EqualFold(c.Type, CurveLinear), strings.EqualFold(c.Type, CurveLogarithmic):
+	case strings.EqualFold(c.Type, CurveExponential), strings.EqualFold(c.Type, CurveSCurve):
+		if c.Exponent <= 0 {
+			return fmt.Errorf("curve exponent %f should be greater than 0", c.Exponent)
+		}
+	case strings.EqualFold(c.Type, CurveDB):
+		if c.MinDB >= 0 {
+			return fmt.Errorf("curve minDB %f should be less than 0", c.MinDB)
+		}
+	case strings.EqualFold(c.Type, CurveTable):
+		if len(c.Points) < 2 {
+			return fmt.Errorf("curve table needs at least 2 points")
+		}
+		for i, p := range c.Points {
+			if p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1 {
+				return fmt.Errorf("curve table point %v should be in range [0,1]", p)
+			}
+			if i > 0 && p[0] == c.Points[i-1][0] {
+				return fmt.Errorf("curve table has multiple points for %f", p[0])
+			}
+			// The volume can't go down as the fader goes up, otherwise there's no telling which fader position a
+			// volume came from for feedback. Use a volumeMin greater than volumeMax to reverse the fader instead.
+			if i > 0 && p[1] < c.Points[i-1][1] {
+				return fmt.Errorf("curve table point %v should not have a lower volume than %v", p, c.Points[i-1])
+			}
+		}
+	default:
+		return fmt.Errorf("curve %s should be one of %s, %s, %s, %s, %s, or %s", c.Type, CurveLinear, CurveLogarithmic, CurveExponential, CurveSCurve, CurveDB, CurveTable)
+	}
+	return nil
+}
+
+// Apply takes the normalized fader position x in the range [0,1] and returns the normalized volume.
+func (c *Curve) Apply(x float64) float64 {
+	x = math.Max(0, math.Min(1, x))
+	switch {
+	case strings.EqualFold(c.Type, CurveLogarithmic):
+		// An audio taper, like the volume knobs of most amplifiers, with a 40dB range.
+		return (math.Pow(10, 2*x) - 1) / 99
+	case strings.EqualFold(c.Type, CurveExponential):
+		return math.Pow(x, c.Exponent)
+	case strings.EqualFold(c.Type, CurveSCurve):
+		a := math.Pow(x, c.Exponent)
+		b := math.Pow(1-x, c.Exponent)
+		return a / (a + b)
+	case strings.EqualFold(c.Type, CurveDB):
+		// Linear steps in decibels from minDB up to 0dB, with the very bottom being silent.
+		if x == 0 {
+			return 0
+		}
+		return math.Pow(10, c.MinDB*(1-x)/20)
+	case strings.EqualFold(c.Type, CurveTable):
+		return c.lookup(x)
+	}
+	return x
+}
+
+// Invert is the reverse of Apply, finding the fader position for a normalized volume. The curves never decrease,
+// Validate makes sure of that for the lookup table, so a bisection is good enough. Where the curve is flat the
+// lowest position with the volume is found.
+func (c *Curve) Invert(y float64) float64 {
+	// The ends are checked first so silence goes back to the very bottom, the db curve jumps up from there.
+	if y <= c.Apply(0) {
+		return 0
+	}
+	if y >= c.Apply(1) {
+		return 1
+	}
+	low, high := 0.0, 1.0
+	for i := 0; i < 32; i++ {
+		mid := (low + high) / 2
+		if c.Apply(mid) < y {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return (low + high) / 2
+}
+
+// lookup interpolates between the points of the table, anything outside of the points is held at the nearest one.
+func (c *Curve) lookup(x float64) float64 {
+	if len(c.Points) == 0 {
+		return x
+	}
+	if x <= c.Points[0][0] {
+		return c.Points[0][1]
+	}
+	for i := 1; i < len(c.Points); i++ {
+		p0, p1 := c.Points[i-1], c.Points[i]
+		if x <= p1[0] {
+			return p0[1] + (x-p0[0])/(p1[0]-p0[0])*(p1[1]-p0[1])
+		}
+	}
+	return c.Points[len(c.Points)-1][1]
+}
@@ -0,0 +1,139 @@
+package mixer
+
+import (
+	"math"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const curveTolerance = 1e-6
+
+func curveFromYAML(t *testing.T, s string) Curve {
+	t.Helper()
+	var c Curve
+	if err := yaml.Unmarshal([]byte(s), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCurveApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		curve  string
+		x      float64
+		expect float64
+	}{
+		{"default bottom", `""`, 0, 0},
+		{"linear middle", "linear", 0.25, 0.25},
+		{"linear clamps low", "linear", -1, 0},
+		{"linear clamps high", "linear", 2, 1},
+		{"logarithmic bottom", "logarithmic", 0, 0},
+		{"logarithmic middle", "logarithmic", 0.5, 9.0 / 99},
+		{"logarithmic top", "logarithmic", 1, 1},
+		{"exponential default", "exponential", 0.5, 0.25},
+		{"exponential cubed", "{type: exponential, exponent: 3}", 0.5, 0.125},
+		{"exponential top", "exponential", 1, 1},
+		{"sCurve bottom", "sCurve", 0, 0},
+		{"sCurve middle", "sCurve", 0.5, 0.5},
+		{"sCurve quarter", "sCurve", 0.25, 0.0625 / (0.0625 + 0.5625)},
+		{"sCurve top", "sCurve", 1, 1},
+		{"db silent", "db", 0, 0},
+		{"db middle", "db", 0.5, math.Pow(10, -30.0/20)},
+		{"db minDB", "{type: db, minDB: -20}", 0.5, math.Pow(10, -10.0/20)},
+		{"db top", "db", 1, 1},
+		{"table point", "{type: table, points: [[0, 0], [0.5, 0.2], [1, 1]]}", 0.5, 0.2},
+		{"table between", "{type: table, points: [[0, 0], [0.5, 0.2], [1, 1]]}", 0.75, 0.6},
+		{"table unsorted", "{type: table, points: [[1, 1], [0, 0], [0.5, 0.2]]}", 0.25, 0.1},
+		{"table below first", "{type: table, points: [[0.2, 0.1], [0.8, 0.9]]}", 0.1, 0.1},
+		{"table above last", "{type: table, points: [[0.2, 0.1], [0.8, 0.9]]}", 0.9, 0.9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := curveFromYAML(t, tt.curve)
+			if err := c.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			if y := c.Apply(tt.x); math.Abs(y-tt.expect) > curveTolerance {
+				t.Errorf("Apply(%f) = %f, expected %f", tt.x, y, tt.expect)
+			}
+		})
+	}
+}
+
+func TestCurveInvert(t *testing.T) {
+	curves := []string{
+		"linear",
+		"logarithmic",
+		"exponential",
+		"{type: exponential, exponent: 0.5}",
+		"sCurve",
+		"{type: sCurve, exponent: 4}",
+		"db",
+		"{type: db, minDB: -90}",
+		"{type: table, points: [[0, 0], [0.5, 0.2], [1, 1]]}",
+		"{type: table, points: [[0, 0.1], [0.3, 0.4], [0.6, 0.5], [1, 0.9]]}",
+	}
+	for _, s := range curves {
+		t.Run(s, func(t *testing.T) {
+			c := curveFromYAML(t, s)
+			if err := c.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i <= 20; i++ {
+				x := float64(i) / 20
+				y := c.Apply(x)
+				if got := c.Apply(c.Invert(y)); math.Abs(got-y) > curveTolerance {
+					t.Errorf("Apply(Invert(%f)) = %f", y, got)
+				}
+				// The db curve jumps from silent up to minDB, so positions just above 0 can't be found again.
+				if x > 0 && y > 0 && y < 1 {
+					if got := c.Invert(y); math.Abs(got-x) > curveTolerance {
+						t.Errorf("Invert(Apply(%f)) = %f", x, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCurveInvertFlat(t *testing.T) {
+	c := curveFromYAML(t, "{type: table, points: [[0, 0], [0.25, 0.5], [0.75, 0.5], [1, 1]]}")
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if x := c.Invert(0.5); math.Abs(x-0.25) > curveTolerance {
+		t.Errorf("Invert(0.5) = %f, expected the lowest position 0.25", x)
+	}
+}
+
+func TestCurveValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve string
+		valid bool
+	}{
+		{"default", `""`, true},
+		{"linear any case", "LINEAR", true},
+		{"unknown", "wobbly", false},
+		{"exponential zero", "{type: exponential, exponent: 0}", false},
+		{"sCurve negative", "{type: sCurve, exponent: -1}", false},
+		{"db positive", "{type: db, minDB: 6}", false},
+		{"table", "{type: table, points: [[0, 0], [1, 1]]}", true},
+		{"table flat", "{type: table, points: [[0, 0.5], [1, 0.5]]}", true},
+		{"table one point", "{type: table, points: [[0, 0]]}", false},
+		{"table out of range", "{type: table, points: [[0, 0], [1, 1.5]]}", false},
+		{"table duplicate", "{type: table, points: [[0, 0], [0.5, 0.2], [0.5, 0.4], [1, 1]]}", false},
+		{"table decreasing", "{type: table, points: [[0, 0], [0.5, 0.8], [1, 0.6]]}", false},
+		{"table reversed", "{type: table, points: [[0, 1], [1, 0]]}", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := curveFromYAML(t, tt.curve)
+			if err := c.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, expected valid %t", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package mixer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Relative encoders send how far they've been turned instead of a position. These are the common
+// ways of packing a signed number of steps into the 7-bit value.
+const (
+	EncoderAbsolute       = ""
+	EncoderTwosComplement = "twosComplement"
+	EncoderSignedBit      = "signedBit"
+	EncoderBinaryOffset   = "binaryOffset"
+)
+
+const defaultEncoderStep = 0.02
+
+func validateEncoder(encoder string, step float32) error {
+	switch {
+	case encoder == EncoderAbsolute:
+		return nil
+	case strings.EqualFold(encoder, EncoderTwosComplement),
+		strings.EqualFold(encoder, EncoderSignedBit),
+		strings.EqualFold(encoder, EncoderBinaryOffset):
+	default:
+		return fmt.Errorf("encoder %s should be one of %s, %s, or %s", encoder, EncoderTwosComplement, EncoderSignedBit, EncoderBinaryOffset)
+	}
+	if step <= 0 || step > 1 {
+		return fmt.Errorf("encoder step %f should be in range (0,1]", step)
+	}
+	return nil
+}
+
+// IsRelative reports if the mapping is for a relative encoder instead of an absolute fader or knob.
+func (m *Mapping) IsRelative() bool {
+	return m.Encoder != EncoderAbsolute
+}
+
+// EncoderTicks decodes the value sent by a relative encoder into a signed number of steps it was turned.
+func (m *Mapping) EncoderTicks(value int) int {
+	value &= 0x7F
+	switch {
+	case strings.EqualFold(m.Encoder, EncoderTwosComplement):
+		if value >= 64 {
+			return value - 128
+		}
+		return value
+	case strings.EqualFold(m.Encoder, EncoderSignedBit):
+		if value&0x40 != 0 {
+			return -(value & 0x3F)
+		}
+		return value & 0x3F
+	case strings.EqualFold(m.Encoder, EncoderBinaryOffset):
+		return value - 64
+	}
+	return 0
+}
+
+// StepVolumeLevel applies a number of encoder ticks to the current volume and returns the new volume.
+// The step is applied in the direction of volumeMin to volumeMax, so reversed mappings turn the other way,
+// and the result stays within the volume range.
+func (m *Mapping) StepVolumeLevel(current float32, ticks int) float32 {
+	delta := float32(ticks) * m.Step
+	low, high := m.VolumeMin, m.VolumeMax
+	if low > high {
+		delta = -delta
+		low, high = high, low
+	}
+	next := current + delta
+	if next < low {
+		return low
+	}
+	if next > high {
+		return high
+	}
+	return next
+}
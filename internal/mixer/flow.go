@@ -0,0 +1,23 @@
+package mixer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Flows pick which audio sessions a mapping controls, the ones playing on output devices, the ones recording
+// from input devices, or both.
+const (
+	FlowOutput = "output"
+	FlowInput  = "input"
+	FlowAny    = "any"
+)
+
+func validateFlow(flow string) error {
+	for _, f := range []string{FlowOutput, FlowInput, FlowAny} {
+		if strings.EqualFold(flow, f) {
+			return nil
+		}
+	}
+	return fmt.Errorf("flow %s should be one of %s, %s, or %s", flow, FlowOutput, FlowInput, FlowAny)
+}
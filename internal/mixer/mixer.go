@@ -2,7 +2,11 @@ package mixer
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
+	"github.com/GregoryDosh/automidically/internal/midi/message"
+	"github.com/GregoryDosh/automidically/internal/pattern"
 	"github.com/sirupsen/logrus"
 )
 
@@ -11,40 +15,103 @@ var (
 )
 
 type Mapping struct {
-	Cc          int      `yaml:"cc"`
-	HardwareMin int      `yaml:"hardwareMin"`
-	HardwareMax int      `yaml:"hardwareMax"`
-	VolumeMin   float32  `yaml:"volumeMin"`
-	VolumeMax   float32  `yaml:"volumeMax"`
-	Filename    []string `yaml:"-"`
-	Special     []string `yaml:"-"`
-	Device      []string `yaml:"-"`
+	message.Trigger `yaml:",inline"`
+	// ID is the Key of the mapping, plus a count to tell copies of the same mapping apart. It's set when the routing
+	// table is built so the state kept for a mapping, like soft takeover, survives a reload as long as the mapping
+	// itself doesn't change, even if others are added, removed, or moved around it.
+	ID            string   `yaml:"-"`
+	HardwareMin   int      `yaml:"hardwareMin"`
+	HardwareMax   int      `yaml:"hardwareMax"`
+	VolumeMin     float32  `yaml:"volumeMin"`
+	VolumeMax     float32  `yaml:"volumeMax"`
+	Encoder       string   `yaml:"encoder"`
+	Step          float32  `yaml:"step"`
+	Takeover      string   `yaml:"takeover"`
+	Curve         Curve    `yaml:"curve"`
+	Action        string   `yaml:"action"`
+	Threshold     int      `yaml:"threshold"`
+	InitialVolume *float32 `yaml:"initialVolume"`
+	Role          []string `yaml:"-"`
+	ProcessTree   bool     `yaml:"processTree"`
+	SessionDevice string   `yaml:"sessionDevice"`
+	Flow          string   `yaml:"flow"`
+	Filename      []string `yaml:"-"`
+	Path          []string `yaml:"-"`
+	Title         []string `yaml:"-"`
+	DisplayName   []string `yaml:"-"`
+	Special       []string `yaml:"-"`
+	Device        []string `yaml:"-"`
+	// Patterns are the filenames, device names, and such compiled ahead of time by CompilePatterns, so the
+	// mapping can be matched against audio sessions without compiling them each time.
+	Patterns pattern.Set `yaml:"-"`
+}
+
+// Key describes everything about the mapping from the config, two mappings with the same Key behave the same.
+func (m *Mapping) Key() string {
+	c := *m
+	c.ID = ""
+	c.Patterns = nil
+	initialVolume := "none"
+	if m.InitialVolume != nil {
+		initialVolume = fmt.Sprint(*m.InitialVolume)
+	}
+	c.InitialVolume = nil
+	return fmt.Sprintf("%+v initialVolume:%s", c, initialVolume)
 }
 
 func (m *Mapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// This is so we can set some default values if not specified in the config.
 	type rawMapping Mapping
 	raw := rawMapping{
+		Trigger:     message.DefaultTrigger(),
 		HardwareMin: 0,
-		HardwareMax: 127,
 		VolumeMin:   0,
 		VolumeMax:   1,
+		Step:        defaultEncoderStep,
+		Action:      ActionVolume,
+		Threshold:   defaultThreshold,
+		Flow:        FlowOutput,
 	}
 	if err := unmarshal(&raw); err != nil {
 		return err
 	}
 
+	// The default hardwareMax depends on the type of message, e.g. pitch bend & high resolution cc are 14-bit.
+	rMax := struct {
+		HardwareMax *int `yaml:"hardwareMax"`
+	}{}
+	_ = unmarshal(&rMax)
+	if rMax.HardwareMax == nil {
+		raw.HardwareMax = raw.Trigger.MaxValue()
+	}
+
 	// This is kludgy, but with it we can infer the params as strings or slices.
 	{
 		rString := struct {
-			Filename string
-			Special  string
-			Device   string
+			Role        string
+			Filename    string
+			Path        string
+			Title       string
+			DisplayName string `yaml:"displayName"`
+			Special     string
+			Device      string
 		}{}
 		_ = unmarshal(&rString)
+		if rString.Role != "" {
+			raw.Role = []string{rString.Role}
+		}
 		if rString.Filename != "" {
 			raw.Filename = []string{rString.Filename}
 		}
+		if rString.Path != "" {
+			raw.Path = []string{rString.Path}
+		}
+		if rString.Title != "" {
+			raw.Title = []string{rString.Title}
+		}
+		if rString.DisplayName != "" {
+			raw.DisplayName = []string{rString.DisplayName}
+		}
 		if rString.Special != "" {
 			raw.Special = []string{rString.Special}
 		}
@@ -52,14 +119,30 @@ func (m *Mapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
 			raw.Device = []string{rString.Device}
 		}
 		rSlice := struct {
-			Filename []string
-			Special  []string
-			Device   []string
+			Role        []string
+			Filename    []string
+			Path        []string
+			Title       []string
+			DisplayName []string `yaml:"displayName"`
+			Special     []string
+			Device      []string
 		}{}
 		_ = unmarshal(&rSlice)
+		if len(rSlice.Role) > 0 {
+			raw.Role = rSlice.Role
+		}
 		if len(rSlice.Filename) > 0 {
 			raw.Filename = rSlice.Filename
 		}
+		if len(rSlice.Path) > 0 {
+			raw.Path = rSlice.Path
+		}
+		if len(rSlice.Title) > 0 {
+			raw.Title = rSlice.Title
+		}
+		if len(rSlice.DisplayName) > 0 {
+			raw.DisplayName = rSlice.DisplayName
+		}
 		if len(rSlice.Special) > 0 {
 			raw.Special = rSlice.Special
 		}
@@ -68,11 +151,45 @@ func (m *Mapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 	}
 
+	if len(raw.Role) == 0 {
+		raw.Role = defaultRoles()
+	}
+
 	*m = Mapping(raw)
 	return nil
 }
 
+// CompilePatterns fills in Patterns, it should be called after the mapping is validated.
+func (m *Mapping) CompilePatterns() {
+	m.Patterns = pattern.NewSet(m.Filename, m.Path, m.Title, m.DisplayName, m.Device, []string{m.SessionDevice})
+}
+
 func (m *Mapping) Validate() error {
+	if err := m.Trigger.Validate(); err != nil {
+		return err
+	}
+	if err := pattern.Validate(m.Filename); err != nil {
+		return err
+	}
+	if err := pattern.Validate(m.Device); err != nil {
+		return err
+	}
+	if !strings.EqualFold(m.SessionDevice, "default") {
+		if err := pattern.Validate([]string{m.SessionDevice}); err != nil {
+			return err
+		}
+	}
+	if err := validateFlow(m.Flow); err != nil {
+		return err
+	}
+	for _, patterns := range [][]string{m.Path, m.Title, m.DisplayName} {
+		if err := pattern.Validate(patterns); err != nil {
+			return err
+		}
+	}
+	if m.HardwareMin < 0 || m.HardwareMax > m.Trigger.MaxValue() {
+		return fmt.Errorf("hardware range [%d,%d] should be within [0,%d]", m.HardwareMin, m.HardwareMax, m.Trigger.MaxValue())
+	}
 	if m.HardwareMin > m.HardwareMax {
 		return fmt.Errorf("hardware minimum %d should not be greater than maximum %d", m.HardwareMin, m.HardwareMax)
 	}
@@ -82,5 +199,64 @@ func (m *Mapping) Validate() error {
 	if m.VolumeMax < 0 || m.VolumeMax > 1 {
 		return fmt.Errorf("volume maximum %f should be in range [0,1]", m.VolumeMax)
 	}
+	if m.InitialVolume != nil && (*m.InitialVolume < 0 || *m.InitialVolume > 1) {
+		return fmt.Errorf("initial volume %f should be in range [0,1]", *m.InitialVolume)
+	}
+	if err := validateEncoder(m.Encoder, m.Step); err != nil {
+		return err
+	}
+	if m.IsRelative() && m.MaxValue() != 127 {
+		return fmt.Errorf("encoder %s only supports 7-bit messages", m.Encoder)
+	}
+	if err := m.Curve.Validate(); err != nil {
+		return err
+	}
+	if err := validateTakeover(m.Takeover); err != nil {
+		return err
+	}
+	if m.IsRelative() && m.HasTakeover() {
+		return fmt.Errorf("takeover %s can't be used with encoder %s", m.Takeover, m.Encoder)
+	}
+	if err := validateAction(m.Action); err != nil {
+		return err
+	}
+	if (m.IsMuteAction() || m.IsDefaultDeviceAction()) && (m.IsRelative() || m.HasTakeover()) {
+		return fmt.Errorf("action %s can't be used with an encoder or takeover", m.Action)
+	}
+	if err := validateRoles(m.Role); err != nil {
+		return err
+	}
+	if m.IsDefaultDeviceAction() && len(m.Device) == 0 {
+		return fmt.Errorf("action %s needs at least one device", m.Action)
+	}
 	return nil
 }
+
+// VolumeLevel takes the value sent by the MIDI device and turns it into the volume to set, clamping it to the
+// hardware range, shaping it with the curve, and mapping it to the volume range.
+func (m *Mapping) VolumeLevel(value int) float32 {
+	clampedValue := clampValue(value, m.HardwareMin, m.HardwareMax)
+	position := mapValue(clampedValue, m.HardwareMin, m.HardwareMax, 0, 1)
+	return float32(m.Curve.Apply(float64(position)))*(m.VolumeMax-m.VolumeMin) + m.VolumeMin
+}
+
+// clampValue is for taking the integer values from the MIDI device and clamping it to a given range.
+func clampValue(value, inputMin, inputMax int) int {
+	if value > inputMax {
+		return inputMax
+	}
+	if value < inputMin {
+		return inputMin
+	}
+	return value
+}
+
+// mapValue will take an input value along with input range and map it to an output range to allow
+// for nice things like limiting total range output, or reverse a range if desired.
+func mapValue(value, inputMin, inputMax int, outputMin, outputMax float32) float32 {
+	if inputMax == inputMin {
+		return outputMin
+	}
+	value = int(math.Max(float64(inputMin), math.Min(float64(inputMax), float64(value))))
+	return float32(value-inputMin)/float32(inputMax-inputMin)*float32(outputMax-outputMin) + float32(outputMin)
+}
@@ -0,0 +1,114 @@
+package mixer
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Takeover modes decide what happens when a fader is moved while it doesn't match the volume of its target,
+// e.g. after the volume was changed in the Windows volume mixer or by another mapping.
+const (
+	// TakeoverNone sets the volume to the fader's position right away, this is the default.
+	TakeoverNone = ""
+	// TakeoverPickup ignores the fader until it crosses the current volume, then follows it.
+	TakeoverPickup = "pickup"
+	// TakeoverScale moves the volume in the direction of the fader, scaled so that both meet at the end of the range.
+	TakeoverScale = "scale"
+)
+
+func validateTakeover(takeover string) error {
+	switch {
+	case takeover == TakeoverNone,
+		strings.EqualFold(takeover, TakeoverPickup),
+		strings.EqualFold(takeover, TakeoverScale):
+		return nil
+	}
+	return fmt.Errorf("takeover %s should be one of %s or %s", takeover, TakeoverPickup, TakeoverScale)
+}
+
+// HasTakeover reports if the mapping uses soft takeover and needs the current volume of its targets.
+func (m *Mapping) HasTakeover() bool {
+	return m.Takeover != TakeoverNone
+}
+
+// takeoverTolerance is how close the fader and volume need to be to consider them the same, about one hardware step.
+func (m *Mapping) takeoverTolerance() float32 {
+	tolerance := float32(0.005)
+	if m.HardwareMax > m.HardwareMin {
+		step := float32(math.Abs(float64(m.VolumeMax-m.VolumeMin))) / float32(m.HardwareMax-m.HardwareMin)
+		if step > tolerance {
+			tolerance = step
+		}
+	}
+	return tolerance
+}
+
+// TakeoverState is kept for each pairing of a mapping and a target to track the fader in relation to the volume.
+type TakeoverState struct {
+	lastFader float32
+	lastSet   float32
+	seen      bool
+	engaged   bool
+}
+
+// Next takes the current volume of the target and the volume the fader is asking for, and decides the volume
+// to set the target to. If the target shouldn't be changed then ok is false.
+func (s *TakeoverState) Next(m *Mapping, current, fader float32) (volume float32, ok bool) {
+	tolerance := m.takeoverTolerance()
+	near := func(a, b float32) bool {
+		return math.Abs(float64(a-b)) <= float64(tolerance)
+	}
+	defer func() {
+		s.lastFader = fader
+		s.seen = true
+		if ok {
+			s.lastSet = volume
+		}
+	}()
+
+	// If the volume isn't what it was last set to, then something else changed it and the fader has to catch up again.
+	if s.engaged && !near(current, s.lastSet) {
+		s.engaged = false
+	}
+	if !s.engaged && near(current, fader) {
+		s.engaged = true
+	}
+	if s.engaged {
+		return fader, true
+	}
+
+	switch {
+	case strings.EqualFold(m.Takeover, TakeoverPickup):
+		// Crossing the current volume between two messages counts as picking it up.
+		if s.seen && (s.lastFader-current)*(fader-current) <= 0 {
+			s.engaged = true
+			return fader, true
+		}
+		return 0, false
+
+	case strings.EqualFold(m.Takeover, TakeoverScale):
+		if !s.seen {
+			return 0, false
+		}
+		low, high := m.VolumeMin, m.VolumeMax
+		if low > high {
+			low, high = high, low
+		}
+		next := current
+		if fader > s.lastFader && high-s.lastFader > 0 {
+			next = current + (fader-s.lastFader)*(high-current)/(high-s.lastFader)
+		}
+		if fader < s.lastFader && s.lastFader-low > 0 {
+			next = current - (s.lastFader-fader)*(current-low)/(s.lastFader-low)
+		}
+		next = float32(math.Max(float64(low), math.Min(float64(high), float64(next))))
+		if near(next, fader) {
+			s.engaged = true
+			next = fader
+		}
+		return next, true
+	}
+
+	return fader, true
+}
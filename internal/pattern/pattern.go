@@ -0,0 +1,131 @@
+package pattern
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Prefixes that can be put in front of a filename or device name in the config to match it in other ways.
+// Without a prefix the name has to match exactly, ignoring case. contains: needs something after it, it would
+// match every name otherwise.
+const (
+	PrefixGlob     = "glob:"
+	PrefixRegex    = "regex:"
+	PrefixContains = "contains:"
+)
+
+// Pattern is a compiled filename or device name from the config. All of the kinds are case insensitive.
+type Pattern struct {
+	raw      string
+	exact    string
+	contains string
+	re       *regexp.Regexp
+}
+
+// Compile parses the pattern s, returning an error if it's an invalid glob or regex, or an empty contains.
+// Nothing is cached here, patterns that are matched over and over should be compiled once into a Set.
+func Compile(s string) (*Pattern, error) {
+	p := &Pattern{raw: s}
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasPrefix(lower, PrefixGlob):
+		re, err := regexp.Compile("(?i)^" + globToRegex(s[len(PrefixGlob):]) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %s: %w", s, err)
+		}
+		p.re = re
+	case strings.HasPrefix(lower, PrefixRegex):
+		re, err := regexp.Compile("(?i)" + s[len(PrefixRegex):])
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %s: %w", s, err)
+		}
+		p.re = re
+	case strings.HasPrefix(lower, PrefixContains):
+		p.contains = strings.ToLower(s[len(PrefixContains):])
+		if p.contains == "" {
+			return nil, fmt.Errorf("invalid pattern %s: contains needs something to look for", s)
+		}
+	default:
+		p.exact = s
+	}
+	return p, nil
+}
+
+// Match is a shortcut to compile the pattern and match name against it. Invalid patterns don't match anything,
+// they should have been reported when the config was loaded. The pattern is compiled every time, so a Set is
+// better for anything done often.
+func Match(pattern, name string) bool {
+	p, err := Compile(pattern)
+	if err != nil {
+		return false
+	}
+	return p.Match(name)
+}
+
+// Match reports if name matches the pattern.
+func (p *Pattern) Match(name string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(name)
+	case p.contains != "":
+		return strings.Contains(strings.ToLower(name), p.contains)
+	}
+	return strings.EqualFold(p.exact, name)
+}
+
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// Set holds patterns that were compiled ahead of time, by the way they're written in the config. It isn't changed
+// after it's made, so matching against it doesn't need any locking.
+type Set map[string]*Pattern
+
+// NewSet compiles all of the patterns into a Set. Invalid ones are left out, they wouldn't match anything anyway.
+func NewSet(patterns ...[]string) Set {
+	s := Set{}
+	for _, list := range patterns {
+		for _, raw := range list {
+			if p, err := Compile(raw); err == nil {
+				s[raw] = p
+			}
+		}
+	}
+	return s
+}
+
+// Match is the same as the Match function, using the compiled pattern from the set when it's there.
+// A nil Set is fine and always falls back to compiling.
+func (s Set) Match(pattern, name string) bool {
+	if p, ok := s[pattern]; ok {
+		return p.Match(name)
+	}
+	return Match(pattern, name)
+}
+
+// Validate compiles each of the patterns and returns the first error found.
+func Validate(patterns []string) error {
+	for _, s := range patterns {
+		if _, err := Compile(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// globToRegex converts the * and ? wildcards of a glob to a regex, everything else is matched literally.
+func globToRegex(glob string) string {
+	var b strings.Builder
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
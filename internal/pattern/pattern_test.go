@@ -0,0 +1,87 @@
+package pattern
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		match   []string
+		noMatch []string
+	}{
+		{"exact", "game.exe", []string{"game.exe", "GAME.EXE"}, []string{"game.exe2", "my game.exe", "game"}},
+		{"exact with regex characters", "a+b.exe", []string{"A+B.exe"}, []string{"aab.exe", "a+bxexe"}},
+		{"glob star", "glob:game*.exe", []string{"game.exe", "Game2.exe", "game launcher.exe"}, []string{"mygame.exe", "game.exe.bak"}},
+		{"glob question", "glob:game?.exe", []string{"game1.exe"}, []string{"game.exe", "game12.exe"}},
+		{"glob is anchored", "glob:game", []string{"game"}, []string{"game.exe", "a game"}},
+		{"glob escapes", "glob:(a)[b].exe", []string{"(A)[b].exe"}, []string{"ab.exe", "(a)b.exe"}},
+		{"glob dot is literal", "glob:a.exe", []string{"a.exe"}, []string{"abexe"}},
+		{"glob prefix case", "GLOB:game*", []string{"game.exe"}, []string{"glob:game"}},
+		{"regex", "regex:^(chrome|firefox)\\.exe$", []string{"chrome.exe", "FIREFOX.EXE"}, []string{"chrome.exe2", "xfirefox.exe"}},
+		{"regex isn't anchored", "regex:fire", []string{"firefox.exe", "bonfire"}, []string{"fir"}},
+		{"regex prefix case", "Regex:^a$", []string{"A"}, []string{"ba"}},
+		{"contains", "contains:Headset", []string{"Headset Earphone", "USB headset", "headset"}, []string{"Head set", "Speakers"}},
+		{"contains prefix case", "CONTAINS:set", []string{"headset"}, []string{"se"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Compile(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			set := NewSet([]string{tt.pattern})
+			for _, name := range tt.match {
+				if !p.Match(name) || !Match(tt.pattern, name) || !set.Match(tt.pattern, name) {
+					t.Errorf("%s should match %s", tt.pattern, name)
+				}
+			}
+			for _, name := range tt.noMatch {
+				if p.Match(name) || Match(tt.pattern, name) || set.Match(tt.pattern, name) {
+					t.Errorf("%s shouldn't match %s", tt.pattern, name)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileInvalid(t *testing.T) {
+	for _, s := range []string{
+		"regex:(",
+		"regex:a[",
+		"contains:",
+		"Contains:",
+	} {
+		if _, err := Compile(s); err == nil {
+			t.Errorf("%s should be invalid", s)
+		}
+		if Match(s, "anything") {
+			t.Errorf("invalid %s shouldn't match anything", s)
+		}
+	}
+	if err := Validate([]string{"a.exe", "regex:(", "glob:*"}); err == nil {
+		t.Error("Validate should return the invalid pattern")
+	}
+	if err := Validate([]string{"a.exe", "glob:*", "contains:a"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := NewSet([]string{"glob:a*", "regex:("}, nil, []string{"b.exe"})
+	if len(s) != 2 {
+		t.Errorf("expected the 2 valid patterns in the set, found %d", len(s))
+	}
+	if s["glob:a*"].String() != "glob:a*" {
+		t.Errorf("pattern should keep how it was written, got %s", s["glob:a*"])
+	}
+	// Patterns that aren't in the set, or a nil set, are compiled as needed.
+	if !s.Match("contains:x", "box") {
+		t.Error("pattern missing from the set should still match")
+	}
+	var empty Set
+	if !empty.Match("glob:a*", "abc") {
+		t.Error("nil set should still match")
+	}
+}
@@ -0,0 +1,42 @@
+package process
+
+import (
+	"github.com/mitchellh/go-ps"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	log = logrus.WithField("module", "process")
+)
+
+// maxAncestors limits how far up the process tree we'll go, in case of loops from reused process IDs.
+const maxAncestors = 16
+
+// Ancestors returns the parent, grandparent, etc. of the process, nearest first. The whole tree is walked from a
+// single snapshot of the processes, looking each one up separately takes a snapshot every time.
+func Ancestors(pid int) []ps.Process {
+	ancestors := []ps.Process{}
+	processes, err := ps.Processes()
+	if err != nil {
+		log.Tracef("unable to list processes: %s", err)
+		return ancestors
+	}
+	byPID := map[int]ps.Process{}
+	for _, p := range processes {
+		byPID[p.Pid()] = p
+	}
+
+	seen := map[int]bool{pid: true}
+	p, ok := byPID[pid]
+	for i := 0; ok && i < maxAncestors; i++ {
+		pid = p.PPid()
+		if pid <= 0 || seen[pid] {
+			break
+		}
+		seen[pid] = true
+		if p, ok = byPID[pid]; ok {
+			ancestors = append(ancestors, p)
+		}
+	}
+	return ancestors
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package process
+
+import (
+	"fmt"
+	"os"
+)
+
+// Path returns the full path of the executable for the process, or an empty string if it can't be found.
+// This relies on /proc, so it's only found on Linux.
+func Path(pid int) string {
+	if pid <= 0 {
+		return ""
+	}
+	path, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		log.Tracef("unable to get path of process %d: %s", pid, err)
+		return ""
+	}
+	return path
+}
+
+// WindowTitles returns nothing, there's no portable way of finding the windows of a process outside of Windows.
+func WindowTitles(pid int) []string {
+	return nil
+}
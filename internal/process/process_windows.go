@@ -0,0 +1,85 @@
+package process
+
+import (
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/lxn/win"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32                       = windows.NewLazySystemDLL("kernel32.dll")
+	user32                         = windows.NewLazySystemDLL("user32.dll")
+	procQueryFullProcessImageNameW = kernel32.NewProc("QueryFullProcessImageNameW")
+	procGetWindowTextW             = user32.NewProc("GetWindowTextW")
+	procGetWindowTextLengthW       = user32.NewProc("GetWindowTextLengthW")
+	enumWindowsCallback            = syscall.NewCallback(enumWindowsProc)
+	titlesLock                     sync.Mutex
+	titlesByPID                    map[int][]string
+	titlesUpdated                  time.Time
+)
+
+// titlesTTL is how long the window titles are kept before going through the windows again. Matching a title looks
+// at every audio session, so without this all of the windows would be gone through for each one of them.
+const titlesTTL = time.Second * 2
+
+// Path returns the full path of the executable for the process, or an empty string if it can't be found.
+// https://docs.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-queryfullprocessimagenamew
+func Path(pid int) string {
+	if pid <= 0 {
+		return ""
+	}
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		log.Tracef("unable to open process %d: %s", pid, err)
+		return ""
+	}
+	defer windows.CloseHandle(h)
+
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	size := uint32(len(buf))
+	if r, _, err := procQueryFullProcessImageNameW.Call(uintptr(h), 0, uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size))); r == 0 {
+		log.Tracef("unable to get path of process %d: %s", pid, err)
+		return ""
+	}
+	return windows.UTF16ToString(buf[:size])
+}
+
+// WindowTitles returns the titles of the visible top level windows belonging to the process. The titles of all of
+// the processes are found at once and kept for titlesTTL, so they can be a little behind.
+func WindowTitles(pid int) []string {
+	titlesLock.Lock()
+	defer titlesLock.Unlock()
+	if titlesByPID == nil || time.Since(titlesUpdated) > titlesTTL {
+		titlesByPID = map[int][]string{}
+		// Without a parent this enumerates the top level windows, the same as EnumWindows.
+		win.EnumChildWindows(0, enumWindowsCallback, 0)
+		titlesUpdated = time.Now()
+	}
+	titles := titlesByPID[pid]
+	if titles == nil {
+		return []string{}
+	}
+	return titles
+}
+
+// enumWindowsProc collects the title of each visible window into titlesByPID. Windows only allows a limited number
+// of callbacks to be created, so there's one shared callback with the state protected by titlesLock.
+func enumWindowsProc(hwnd win.HWND, lParam uintptr) uintptr {
+	var windowPID uint32
+	win.GetWindowThreadProcessId(hwnd, &windowPID)
+	if !win.IsWindowVisible(hwnd) {
+		return 1
+	}
+	length, _, _ := procGetWindowTextLengthW.Call(uintptr(hwnd))
+	if length == 0 {
+		return 1
+	}
+	buf := make([]uint16, length+1)
+	procGetWindowTextW.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	titlesByPID[int(windowPID)] = append(titlesByPID[int(windowPID)], windows.UTF16ToString(buf))
+	return 1
+}
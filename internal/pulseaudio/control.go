@@ -0,0 +1,380 @@
+package pulseaudio
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/GregoryDosh/automidically/internal/process"
+	"github.com/mitchellh/go-ps"
+)
+
+// volumeNorm is 100% for PulseAudio, volumes can go past it but that's left to pavucontrol & friends.
+const volumeNorm = 0x10000
+
+// volumeLevel is the loudest of the channels on the scale of 0-1, like pavucontrol shows it.
+func volumeLevel(volumes []uint32) float32 {
+	max := uint32(0)
+	for _, v := range volumes {
+		if v > max {
+			max = v
+		}
+	}
+	if max >= volumeNorm {
+		return 1
+	}
+	return float32(max) / volumeNorm
+}
+
+// channelBalance is each channel relative to the loudest one, or nil if they're all silent.
+func channelBalance(volumes []uint32) []float64 {
+	max := uint32(0)
+	for _, v := range volumes {
+		if v > max {
+			max = v
+		}
+	}
+	if max == 0 {
+		return nil
+	}
+	balance := make([]float64, len(volumes))
+	for i, v := range volumes {
+		balance[i] = float64(v) / float64(max)
+	}
+	return balance
+}
+
+// scaleVolume sets the loudest channel to v on the scale of 0-1, the other channels follow the balance so it stays
+// the same. Without a balance for the channels they're all set to v.
+func scaleVolume(volumes []uint32, balance []float64, v float32) []uint32 {
+	target := float64(v) * volumeNorm
+	scaled := make([]uint32, len(volumes))
+	for i := range volumes {
+		if len(balance) != len(volumes) {
+			scaled[i] = uint32(math.Round(target))
+			continue
+		}
+		scaled[i] = uint32(math.Round(target * balance[i]))
+	}
+	return scaled
+}
+
+// control is the volume & mute of a sink, source, sink input, or source output. The state is kept up to date
+// from the subscription events, so reading it doesn't need to ask the server.
+// The fields are protected by the lock of the PulseAudio it belongs to.
+type control struct {
+	pa    *PulseAudio
+	index uint32
+	// byName is set for sinks & sources, their commands take a name that's sent empty since the index is used.
+	byName        bool
+	volumeCommand uint32
+	muteCommand   uint32
+	volume        []uint32
+	// balance is the channelBalance of the volume the last time it wasn't silent, so the balance comes back
+	// after the volume has been all of the way down.
+	balance []float64
+	mute    bool
+	// gone is the error returned once the server doesn't have this anymore.
+	gone error
+	// changedAt is the PulseAudio's count of changes as of the last change made to this one.
+	changedAt uint64
+	// describe builds the VolumeChange for the current state, it expects the lock to be held already.
+	describe func(external bool) audio.VolumeChange
+}
+
+func (c *control) GetVolumeLevel() (float32, error) {
+	c.pa.Lock()
+	defer c.pa.Unlock()
+	if c.gone != nil {
+		return 0, c.gone
+	}
+	return volumeLevel(c.volume), nil
+}
+
+func (c *control) GetMute() (bool, error) {
+	c.pa.Lock()
+	defer c.pa.Unlock()
+	if c.gone != nil {
+		return false, c.gone
+	}
+	return c.mute, nil
+}
+
+func (c *control) LiveState() (float32, bool, bool) {
+	c.pa.Lock()
+	defer c.pa.Unlock()
+	return volumeLevel(c.volume), c.mute, c.gone == nil
+}
+
+// SetVolumeLevel takes a float between 0-1 and it will set the volume to that value.
+func (c *control) SetVolumeLevel(v float32) error {
+	if (v < 0) || (1 < v) {
+		return fmt.Errorf("invalid volume level %f", v)
+	}
+
+	c.pa.Lock()
+	if c.gone != nil {
+		c.pa.Unlock()
+		return c.gone
+	}
+	volumes := scaleVolume(c.volume, c.balance, v)
+	c.pa.Unlock()
+
+	err := c.pa.request(c.volumeCommand, func(w *tagWriter) {
+		w.putU32(c.index)
+		if c.byName {
+			w.putString("")
+		}
+		w.putCVolume(volumes)
+	})
+	if err != nil {
+		return c.serverError(err)
+	}
+
+	c.pa.Lock()
+	c.setVolume(volumes)
+	c.changed()
+	change := c.describe(false)
+	c.pa.Unlock()
+	c.pa.queueVolumeChange(change)
+	return nil
+}
+
+// SetMute will mute or unmute.
+func (c *control) SetMute(m bool) error {
+	c.pa.Lock()
+	if c.gone != nil {
+		c.pa.Unlock()
+		return c.gone
+	}
+	c.pa.Unlock()
+
+	err := c.pa.request(c.muteCommand, func(w *tagWriter) {
+		w.putU32(c.index)
+		if c.byName {
+			w.putString("")
+		}
+		w.putBool(m)
+	})
+	if err != nil {
+		return c.serverError(err)
+	}
+
+	c.pa.Lock()
+	c.mute = m
+	c.changed()
+	change := c.describe(false)
+	c.pa.Unlock()
+	c.pa.queueVolumeChange(change)
+	return nil
+}
+
+// serverError turns the server not knowing about the index into the gone error, it was removed before the
+// subscription event about it got here.
+func (c *control) serverError(err error) error {
+	if errors.Is(err, ErrorNoSuchEntity) {
+		c.pa.Lock()
+		defer c.pa.Unlock()
+		return c.gone
+	}
+	return err
+}
+
+// changed counts a change made by Automidically.
+// This expects the lock to be held already.
+func (c *control) changed() {
+	c.pa.changes++
+	c.changedAt = c.pa.changes
+}
+
+// update takes the state from the server, it reports if it's different from what was known. The state is
+// skipped if Automidically made a change since it was asked for, the event of that change will bring it up to date.
+// This expects the lock to be held already.
+func (c *control) update(volume []uint32, mute bool, since uint64) bool {
+	if c.changedAt > since {
+		return false
+	}
+	changed := volumeLevel(volume) != volumeLevel(c.volume) || mute != c.mute
+	c.setVolume(volume)
+	c.mute = mute
+	return changed
+}
+
+// setVolume keeps the volume of the channels, and their balance unless they're all silent.
+// This expects the lock to be held already.
+func (c *control) setVolume(volume []uint32) {
+	c.volume = volume
+	if balance := channelBalance(volume); balance != nil {
+		c.balance = balance
+	}
+}
+
+// endpoint is a sink or source as an audio.Device.
+type endpoint struct {
+	control
+	flow        audio.Flow
+	name        string
+	description string
+	sessions    []*stream
+}
+
+func newEndpoint(pa *PulseAudio, flow audio.Flow, info deviceInfo) *endpoint {
+	e := &endpoint{
+		control: control{
+			pa:            pa,
+			index:         info.index,
+			byName:        true,
+			volumeCommand: commandSetSinkVolume,
+			muteCommand:   commandSetSinkMute,
+			volume:        info.volume,
+			balance:       channelBalance(info.volume),
+			mute:          info.mute,
+		},
+		flow:        flow,
+		name:        info.name,
+		description: info.description,
+	}
+	if flow == audio.Input {
+		e.volumeCommand = commandSetSourceVolume
+		e.muteCommand = commandSetSourceMute
+	}
+	e.describe = func(external bool) audio.VolumeChange {
+		return e.volumeChange(nil, external)
+	}
+	return e
+}
+
+// ID is the name of the sink or source, which PulseAudio keeps the same between restarts.
+func (e *endpoint) ID() string { return e.name }
+
+// Name is the description of the sink or source, the name that's shown to people.
+func (e *endpoint) Name() string     { return e.description }
+func (e *endpoint) Flow() audio.Flow { return e.flow }
+
+// IsDefault is the same for every role, PulseAudio only has the one default sink & source.
+func (e *endpoint) IsDefault(role audio.Role) bool {
+	e.pa.Lock()
+	defer e.pa.Unlock()
+	return e.isDefault()
+}
+
+// isDefault expects the lock to be held already.
+func (e *endpoint) isDefault() bool {
+	if e.flow == audio.Input {
+		return e.name == e.pa.defaultSource
+	}
+	return e.name == e.pa.defaultSink
+}
+
+func (e *endpoint) Sessions() []audio.Session {
+	e.pa.Lock()
+	defer e.pa.Unlock()
+	return e.wrapSessions()
+}
+
+// wrapSessions expects the lock to be held already.
+func (e *endpoint) wrapSessions() []audio.Session {
+	sessions := make([]audio.Session, len(e.sessions))
+	for i, s := range e.sessions {
+		sessions[i] = s
+	}
+	return sessions
+}
+
+// volumeChange describes the current state of the endpoint, or one of its streams.
+// This expects the lock to be held already.
+func (e *endpoint) volumeChange(s *stream, external bool) audio.VolumeChange {
+	change := audio.VolumeChange{
+		DeviceID:   e.name,
+		DeviceName: e.description,
+		Flow:       e.flow.String(),
+		Default:    e.isDefault(),
+		Volume:     volumeLevel(e.volume),
+		Mute:       e.mute,
+		External:   external,
+	}
+	if s != nil {
+		change.Session = s.executable
+		change.ProcessID = s.pid
+		change.Volume = volumeLevel(s.volume)
+		change.Mute = s.mute
+	}
+	return change
+}
+
+// stream is a sink input or source output as an audio.Session. The process is looked up once when the stream
+// shows up, the same as the Windows audio sessions.
+type stream struct {
+	control
+	device      *endpoint
+	pid         int
+	executable  string
+	displayName string
+	system      bool
+	ancestors   []ps.Process
+}
+
+func newStream(pa *PulseAudio, flow audio.Flow, info streamInfo) *stream {
+	s := &stream{
+		control: control{
+			pa:            pa,
+			index:         info.index,
+			volumeCommand: commandSetSinkInputVolume,
+			muteCommand:   commandSetSinkInputMute,
+			volume:        info.volume,
+			balance:       channelBalance(info.volume),
+			mute:          info.mute,
+		},
+		executable:  info.props["application.process.binary"],
+		displayName: info.props["application.name"],
+		system:      info.props["media.role"] == "event",
+	}
+	if flow == audio.Input {
+		s.volumeCommand = commandSetSourceOutputVolume
+		s.muteCommand = commandSetSourceOutputMute
+	}
+	if pid, err := strconv.Atoi(info.props["application.process.id"]); err == nil {
+		s.pid = pid
+		s.ancestors = process.Ancestors(pid)
+	}
+	s.describe = func(external bool) audio.VolumeChange {
+		return s.device.volumeChange(s, external)
+	}
+	return s
+}
+
+func (s *stream) Pid() int            { return s.pid }
+func (s *stream) Executable() string  { return s.executable }
+func (s *stream) DisplayName() string { return s.displayName }
+func (s *stream) IsSystem() bool      { return s.system }
+
+func (s *stream) Path() string {
+	return process.Path(s.pid)
+}
+
+func (s *stream) WindowTitles() []string {
+	return process.WindowTitles(s.pid)
+}
+
+func (s *stream) Ancestors() []audio.Process {
+	ancestors := make([]audio.Process, len(s.ancestors))
+	for i, p := range s.ancestors {
+		ancestors[i] = ancestor{p}
+	}
+	return ancestors
+}
+
+// ancestor is one of the parent processes of a stream.
+type ancestor struct {
+	ps.Process
+}
+
+func (a ancestor) Path() string {
+	return process.Path(a.Pid())
+}
+
+func (a ancestor) WindowTitles() []string {
+	return process.WindowTitles(a.Pid())
+}
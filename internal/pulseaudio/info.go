@@ -0,0 +1,155 @@
+package pulseaudio
+
+import (
+	"github.com/GregoryDosh/automidically/internal/audio"
+)
+
+// serverInfo is the part of the server info about the default devices.
+type serverInfo struct {
+	defaultSink   string
+	defaultSource string
+}
+
+// deviceInfo is a sink or a source.
+type deviceInfo struct {
+	index       uint32
+	name        string
+	description string
+	volume      []uint32
+	mute        bool
+	// monitor is set for the sources that record what's playing on a sink.
+	monitor bool
+}
+
+// streamInfo is a sink input or a source output, the audio of an application going to a sink or coming from a source.
+type streamInfo struct {
+	index uint32
+	// device is the index of the sink or source.
+	device    uint32
+	volume    []uint32
+	mute      bool
+	hasVolume bool
+	props     map[string]string
+}
+
+func (c *conn) serverInfo() (serverInfo, error) {
+	r, err := c.request(commandGetServerInfo, nil)
+	if err != nil {
+		return serverInfo{}, err
+	}
+	r.string() // package name
+	r.string() // package version
+	r.string() // user name
+	r.string() // host name
+	r.sampleSpec()
+	info := serverInfo{
+		defaultSink:   r.string(),
+		defaultSource: r.string(),
+	}
+	return info, r.err
+}
+
+// devices lists the sinks for output, or the sources for input.
+func (c *conn) devices(flow audio.Flow) ([]deviceInfo, error) {
+	command := uint32(commandGetSinkInfoList)
+	if flow == audio.Input {
+		command = commandGetSourceInfoList
+	}
+	r, err := c.request(command, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []deviceInfo{}
+	for !r.done() {
+		d := deviceInfo{}
+		d.index = r.u32()
+		d.name = r.string()
+		d.description = r.string()
+		r.sampleSpec()
+		r.channelMap()
+		r.u32() // owner module
+		d.volume = r.cVolume()
+		d.mute = r.bool()
+		// The monitor source of a sink, or the sink a source is the monitor of.
+		monitorIndex := r.u32()
+		r.string()
+		d.monitor = flow == audio.Input && monitorIndex != invalidIndex
+		r.usec()   // latency
+		r.string() // driver
+		r.u32()    // flags
+		r.proplist()
+		r.usec()   // configured latency
+		r.volume() // base volume
+		r.u32()    // state
+		r.u32()    // volume steps
+		r.u32()    // card
+		ports := r.u32()
+		for i := uint32(0); i < ports && r.err == nil; i++ {
+			r.string() // name
+			r.string() // description
+			r.u32()    // priority
+			if c.version >= 24 {
+				r.u32() // available
+			}
+		}
+		r.string() // active port
+		formats := r.u8()
+		for i := uint8(0); i < formats && r.err == nil; i++ {
+			r.formatInfo()
+		}
+		if r.err != nil {
+			return nil, r.err
+		}
+		devices = append(devices, d)
+	}
+	return devices, r.err
+}
+
+// streams lists the sink inputs for output, or the source outputs for input.
+func (c *conn) streams(flow audio.Flow) ([]streamInfo, error) {
+	command := uint32(commandGetSinkInputInfoList)
+	if flow == audio.Input {
+		command = commandGetSourceOutputInfoList
+	}
+	r, err := c.request(command, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	streams := []streamInfo{}
+	for !r.done() {
+		s := streamInfo{}
+		s.index = r.u32()
+		r.string() // media name
+		r.u32()    // owner module
+		r.u32()    // client
+		s.device = r.u32()
+		r.sampleSpec()
+		r.channelMap()
+		if flow == audio.Output {
+			s.volume = r.cVolume()
+		}
+		r.usec()   // buffer latency
+		r.usec()   // sink or source latency
+		r.string() // resample method
+		r.string() // driver
+		if flow == audio.Output {
+			s.mute = r.bool()
+		}
+		s.props = r.proplist()
+		r.bool() // corked
+		if flow == audio.Input {
+			s.volume = r.cVolume()
+			s.mute = r.bool()
+		}
+		s.hasVolume = r.bool()
+		r.bool() // volume writable
+		r.formatInfo()
+		if r.err != nil {
+			return nil, r.err
+		}
+		streams = append(streams, s)
+	}
+	return streams, r.err
+}
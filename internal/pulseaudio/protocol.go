@@ -0,0 +1,365 @@
+package pulseaudio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The commands of the native protocol, only the ones used here.
+// https://gitlab.freedesktop.org/pulseaudio/pulseaudio/-/blob/master/src/pulsecore/native-common.h
+const (
+	commandError                   = 0
+	commandReply                   = 2
+	commandAuth                    = 8
+	commandSetClientName           = 9
+	commandGetServerInfo           = 20
+	commandGetSinkInfoList         = 22
+	commandGetSourceInfoList       = 24
+	commandGetSinkInputInfoList    = 30
+	commandGetSourceOutputInfoList = 32
+	commandSubscribe               = 35
+	commandSetSinkVolume           = 36
+	commandSetSinkInputVolume      = 37
+	commandSetSourceVolume         = 38
+	commandSetSinkMute             = 39
+	commandSetSourceMute           = 40
+	commandSetDefaultSink          = 44
+	commandSetDefaultSource        = 45
+	commandSubscribeEvent          = 66
+	commandSetSinkInputMute        = 69
+	commandSetSourceOutputVolume   = 98
+	commandSetSourceOutputMute     = 99
+)
+
+const (
+	// protocolVersion is the newest version of the protocol the replies here know how to read. The server talks
+	// to us in whichever is older, its version or this one.
+	protocolVersion = 32
+	// minimumProtocolVersion is PulseAudio 1.0, which is when the volume of recording streams could be changed.
+	minimumProtocolVersion = 22
+	// controlChannel is the channel of packets with commands, the other channels are for audio.
+	controlChannel = 0xFFFFFFFF
+	// eventTag is the tag of the packets the server sends without being asked, like subscription events.
+	eventTag = 0xFFFFFFFF
+	// invalidIndex is used by the server for "none", e.g. a source that isn't the monitor of any sink.
+	invalidIndex = 0xFFFFFFFF
+	// descriptorSize is the size of the header in front of every packet.
+	descriptorSize = 20
+	// maxPacketSize is the largest packet the server is allowed to send, same as PulseAudio's own limit.
+	maxPacketSize = 1024 * 1024 * 16
+	// cookieSize is the size of the authentication cookie.
+	cookieSize = 256
+	// requestTimeout is how long to wait for the server to reply to a command.
+	requestTimeout = time.Second * 5
+	// errorNoSuchEntity is the error code for an index or name the server doesn't know.
+	errorNoSuchEntity = 5
+)
+
+// The facilities of the subscription events, which kind of thing an event is about.
+const (
+	facilitySink         = 0x0
+	facilitySource       = 0x1
+	facilitySinkInput    = 0x2
+	facilitySourceOutput = 0x3
+	facilityServer       = 0x7
+	facilityMask         = 0x0F
+	// subscriptionMask asks for the events of sinks, sources, sink inputs, source outputs, and the server.
+	subscriptionMask = 0x1 | 0x2 | 0x4 | 0x8 | 0x80
+)
+
+// serverErrors are the names of the error codes the server replies with.
+// https://gitlab.freedesktop.org/pulseaudio/pulseaudio/-/blob/master/src/pulse/def.h
+var serverErrors = map[uint32]string{
+	1:  "access denied",
+	2:  "unknown command",
+	3:  "invalid argument",
+	4:  "entity exists",
+	5:  "no such entity",
+	6:  "connection refused",
+	7:  "protocol error",
+	8:  "timeout",
+	9:  "no authentication key",
+	10: "internal error",
+	11: "connection terminated",
+	12: "entity killed",
+	13: "invalid server",
+	19: "not supported",
+}
+
+// reply is the answer to a command, r is at the first value after the command & tag.
+type reply struct {
+	r   *tagReader
+	err error
+}
+
+// conn is a connection to the server over its unix socket. Commands can be sent from any goroutine,
+// the replies are matched up to them by their tag.
+type conn struct {
+	c       net.Conn
+	version uint32
+	tag     uint32
+	pending map[uint32]chan reply
+	onEvent func(event, index uint32)
+	closed  chan bool
+	err     error
+	// writeLock keeps the packets of commands sent at the same time from getting mixed together.
+	writeLock sync.Mutex
+	sync.Mutex
+}
+
+// socketPath finds the socket of the server the same way PulseAudio's own clients do, starting with PULSE_SERVER.
+func socketPath() (string, error) {
+	if server := os.Getenv("PULSE_SERVER"); server != "" {
+		for _, s := range strings.Fields(server) {
+			if strings.HasPrefix(s, "unix:") {
+				return strings.TrimPrefix(s, "unix:"), nil
+			}
+			if strings.HasPrefix(s, "/") {
+				return s, nil
+			}
+		}
+		return "", fmt.Errorf("%w: only unix sockets are supported, PULSE_SERVER is %s", ErrorNoServer, server)
+	}
+	if runtime := os.Getenv("XDG_RUNTIME_DIR"); runtime != "" {
+		return filepath.Join(runtime, "pulse", "native"), nil
+	}
+	return fmt.Sprintf("/run/user/%d/pulse/native", os.Getuid()), nil
+}
+
+// readCookie reads the authentication cookie. PipeWire, and PulseAudio for clients of the same user,
+// don't need it so zeros are sent when there isn't one.
+func readCookie() []byte {
+	paths := []string{os.Getenv("PULSE_COOKIE")}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".config", "pulse", "cookie"), filepath.Join(home, ".pulse-cookie"))
+	}
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if cookie, err := ioutil.ReadFile(p); err == nil && len(cookie) == cookieSize {
+			return cookie
+		}
+	}
+	return make([]byte, cookieSize)
+}
+
+// dial connects to the server at path and goes through the handshake. onEvent is called with the subscription
+// events from the goroutine reading the replies, so it can't send any commands itself.
+func dial(path string, onEvent func(event, index uint32)) (*conn, error) {
+	log.Trace("Enter dial")
+	defer log.Trace("Exit dial")
+
+	nc, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorNoServer, err)
+	}
+	c := &conn{
+		c:       nc,
+		pending: map[uint32]chan reply{},
+		onEvent: onEvent,
+		closed:  make(chan bool),
+	}
+	go c.readLoop()
+
+	r, err := c.request(commandAuth, func(w *tagWriter) {
+		w.putU32(protocolVersion)
+		w.putArbitrary(readCookie())
+	})
+	if err != nil {
+		c.close(err)
+		return nil, err
+	}
+	// The upper bits are flags for shared memory, which isn't used here.
+	c.version = r.u32() & 0xFFFF
+	if r.err != nil {
+		c.close(r.err)
+		return nil, r.err
+	}
+	if c.version > protocolVersion {
+		c.version = protocolVersion
+	}
+	if c.version < minimumProtocolVersion {
+		err := fmt.Errorf("%w: %d", ErrorUnsupportedVersion, c.version)
+		c.close(err)
+		return nil, err
+	}
+	log.Debugf("connected to %s with protocol version %d", path, c.version)
+
+	if _, err := c.request(commandSetClientName, func(w *tagWriter) {
+		w.putProplist(map[string]string{
+			"application.name":           "Automidically",
+			"application.process.binary": filepath.Base(os.Args[0]),
+			"application.process.id":     fmt.Sprintf("%d", os.Getpid()),
+		})
+	}); err != nil {
+		c.close(err)
+		return nil, err
+	}
+
+	if _, err := c.request(commandSubscribe, func(w *tagWriter) {
+		w.putU32(subscriptionMask)
+	}); err != nil {
+		c.close(err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// request sends a command and waits for the reply. args writes the arguments of the command, it can be nil.
+func (c *conn) request(command uint32, args func(w *tagWriter)) (*tagReader, error) {
+	c.Lock()
+	if c.err != nil {
+		c.Unlock()
+		return nil, c.err
+	}
+	c.tag++
+	if c.tag == eventTag {
+		c.tag = 0
+	}
+	tag := c.tag
+	replies := make(chan reply, 1)
+	c.pending[tag] = replies
+	c.Unlock()
+
+	w := &tagWriter{}
+	w.putU32(command)
+	w.putU32(tag)
+	if args != nil {
+		args(w)
+	}
+	if err := c.write(w.Bytes()); err != nil {
+		c.close(err)
+		return nil, err
+	}
+
+	timeout := time.NewTimer(requestTimeout)
+	defer timeout.Stop()
+	select {
+	case rep := <-replies:
+		return rep.r, rep.err
+	case <-timeout.C:
+		c.Lock()
+		delete(c.pending, tag)
+		c.Unlock()
+		return nil, fmt.Errorf("%w: command %d", ErrorTimeout, command)
+	}
+}
+
+// write sends a packet on the control channel.
+func (c *conn) write(payload []byte) error {
+	descriptor := make([]byte, descriptorSize)
+	binary.BigEndian.PutUint32(descriptor[0:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(descriptor[4:], controlChannel)
+
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if _, err := c.c.Write(append(descriptor, payload...)); err != nil {
+		return fmt.Errorf("%w: %s", ErrorConnectionClosed, err)
+	}
+	return nil
+}
+
+// readLoop reads the packets from the server until the connection closes, handing replies to the commands
+// waiting on them and events to onEvent.
+func (c *conn) readLoop() {
+	log.Trace("Enter readLoop")
+	defer log.Trace("Exit readLoop")
+
+	descriptor := make([]byte, descriptorSize)
+	for {
+		if _, err := io.ReadFull(c.c, descriptor); err != nil {
+			c.close(fmt.Errorf("%w: %s", ErrorConnectionClosed, err))
+			return
+		}
+		size := binary.BigEndian.Uint32(descriptor[0:])
+		channel := binary.BigEndian.Uint32(descriptor[4:])
+		if size > maxPacketSize {
+			c.close(fmt.Errorf("%w: packet of %d bytes", ErrorMalformedPacket, size))
+			return
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(c.c, payload); err != nil {
+			c.close(fmt.Errorf("%w: %s", ErrorConnectionClosed, err))
+			return
+		}
+		if channel != controlChannel {
+			continue
+		}
+
+		r := &tagReader{b: payload}
+		command := r.u32()
+		tag := r.u32()
+		if r.err != nil {
+			log.Error(r.err)
+			continue
+		}
+
+		switch command {
+		case commandReply, commandError:
+			c.Lock()
+			replies, ok := c.pending[tag]
+			delete(c.pending, tag)
+			c.Unlock()
+			if !ok {
+				log.Tracef("reply to unknown tag %d", tag)
+				continue
+			}
+			if command == commandError {
+				replies <- reply{err: serverError(r.u32())}
+				continue
+			}
+			replies <- reply{r: r}
+		case commandSubscribeEvent:
+			event := r.u32()
+			index := r.u32()
+			if r.err == nil && c.onEvent != nil {
+				c.onEvent(event, index)
+			}
+		default:
+			log.Tracef("ignoring command %d from the server", command)
+		}
+	}
+}
+
+func serverError(code uint32) error {
+	if code == errorNoSuchEntity {
+		return ErrorNoSuchEntity
+	}
+	if name, ok := serverErrors[code]; ok {
+		return fmt.Errorf("%w: %s", ErrorServer, name)
+	}
+	return fmt.Errorf("%w: error %d", ErrorServer, code)
+}
+
+// closeError is the reason the connection was closed, or nil while it's still open.
+func (c *conn) closeError() error {
+	c.Lock()
+	defer c.Unlock()
+	return c.err
+}
+
+// close shuts the connection down, any commands still waiting on a reply get err.
+// This is safe to call more than once, only the first error is kept.
+func (c *conn) close(err error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	c.c.Close()
+	for tag, replies := range c.pending {
+		replies <- reply{err: err}
+		delete(c.pending, tag)
+	}
+	close(c.closed)
+}
@@ -0,0 +1,543 @@
+package pulseaudio
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+	"github.com/bep/debounce"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	log                     = logrus.WithField("module", "pulseaudio")
+	ErrorNoServer           = errors.New("unable to connect to the pulseaudio server")
+	ErrorNotConnected       = errors.New("not connected to the pulseaudio server")
+	ErrorConnectionClosed   = errors.New("pulseaudio connection closed")
+	ErrorUnsupportedVersion = errors.New("pulseaudio server is too old")
+	ErrorMalformedPacket    = errors.New("malformed pulseaudio packet")
+	ErrorTimeout            = errors.New("pulseaudio server didn't reply in time")
+	ErrorServer             = errors.New("pulseaudio server error")
+	ErrorNoSuchEntity       = fmt.Errorf("%w: no such entity", ErrorServer)
+	ErrorDeviceRemoved      = errors.New("pulseaudio device removed")
+)
+
+// reconnectInterval is how often to try connecting again after the server went away, e.g. when it restarts.
+const reconnectInterval = time.Second * 5
+
+// volumeChangesBuffer is how many changes can wait to be published, more than that are dropped.
+const volumeChangesBuffer = 100
+
+// PulseAudio is the audio.Backend for PulseAudio, or PipeWire through pipewire-pulse, over the native protocol.
+// Sinks & sources are the devices, and the sink inputs & source outputs are the audio sessions.
+// The monitor sources, which record what's playing on a sink, are left out.
+type PulseAudio struct {
+	path          string
+	conn          *conn
+	endpoints     []*endpoint
+	streams       map[indexKey]*stream
+	defaultSink   string
+	defaultSource string
+	// changes counts the changes made by Automidically, so the state from the server isn't older than them.
+	changes                uint64
+	refreshDevicesChannel  chan bool
+	refreshSessionsChannel chan bool
+	devicesChangedChannel  chan bool
+	sessionsChangedChannel chan bool
+	volumeChangesChannel   chan audio.VolumeChange
+	cleanupChan            chan bool
+	subscribers            []func(audio.VolumeChange)
+	onSessionsRefreshed    func(audio.Device, []audio.Session)
+	onDevicesRefreshed     func()
+	callbacksLock          sync.Mutex
+	// syncLock makes sure only one refresh is talking to the server at a time.
+	syncLock sync.Mutex
+	// The embedded lock protects the connection, endpoints, streams, and defaults.
+	sync.Mutex
+}
+
+// indexKey tells sinks from sources, and sink inputs from source outputs, since they have separate indexes.
+type indexKey struct {
+	flow  audio.Flow
+	index uint32
+}
+
+// connect dials the server and replaces the connection.
+func (pa *PulseAudio) connect() error {
+	c, err := dial(pa.path, pa.onEvent)
+	if err != nil {
+		return err
+	}
+	pa.Lock()
+	defer pa.Unlock()
+	pa.conn = c
+	return nil
+}
+
+// connection returns the current connection, or nil when there isn't one.
+func (pa *PulseAudio) connection() *conn {
+	pa.Lock()
+	defer pa.Unlock()
+	return pa.conn
+}
+
+// request sends a command over the current connection, the reply isn't needed for changes.
+func (pa *PulseAudio) request(command uint32, args func(w *tagWriter)) error {
+	c := pa.connection()
+	if c == nil {
+		return ErrorNotConnected
+	}
+	_, err := c.request(command, args)
+	return err
+}
+
+// onEvent is called from the connection for every subscription event. The changes are looked up from the
+// event loop, and a burst of events only needs one look.
+func (pa *PulseAudio) onEvent(event, index uint32) {
+	changed := pa.sessionsChangedChannel
+	switch event & facilityMask {
+	case facilitySink, facilitySource, facilityServer:
+		changed = pa.devicesChangedChannel
+	case facilitySinkInput, facilitySourceOutput:
+	default:
+		return
+	}
+	select {
+	case changed <- true:
+	default:
+	}
+}
+
+// eventLoop is reponsible for the coordination of the logic in the pulseaudio package, the same as the one for Windows.
+func (pa *PulseAudio) eventLoop() {
+	log.Trace("Enter eventLoop")
+	defer log.Trace("Exit eventLoop")
+
+	ddev := debounce.New(time.Second * 1)
+	dses := debounce.New(time.Millisecond * 500)
+	var retry <-chan time.Time
+
+	for {
+		var closed chan bool
+		current := pa.connection()
+		if current != nil {
+			closed = current.closed
+		}
+
+		select {
+		case <-pa.cleanupChan:
+			return
+		case <-pa.refreshDevicesChannel:
+			log.Trace("triggering device refresh")
+			ddev(pa.refreshDevices)
+		case <-pa.refreshSessionsChannel:
+			log.Trace("triggering audio session refresh")
+			dses(pa.refreshSessions)
+		case <-pa.devicesChangedChannel:
+			pa.devicesChanged()
+		case <-pa.sessionsChangedChannel:
+			pa.sessionsChanged()
+		case <-closed:
+			log.Warnf("lost the connection to the server: %s", current.closeError())
+			pa.disconnect()
+			retry = time.After(reconnectInterval)
+		case <-retry:
+			retry = nil
+			if err := pa.connect(); err != nil {
+				log.Debug(err)
+				retry = time.After(reconnectInterval)
+				continue
+			}
+			log.Info("connected to the server again")
+			pa.refreshDevices()
+		}
+	}
+}
+
+// disconnect forgets the connection along with all of the devices & streams, which are gone along with it.
+func (pa *PulseAudio) disconnect() {
+	pa.syncLock.Lock()
+	defer pa.syncLock.Unlock()
+
+	pa.Lock()
+	if pa.conn != nil {
+		pa.conn.close(ErrorConnectionClosed)
+		pa.conn = nil
+	}
+	for _, e := range pa.endpoints {
+		e.gone = ErrorDeviceRemoved
+	}
+	for _, s := range pa.streams {
+		s.gone = audio.ErrorSessionExpired
+	}
+	pa.endpoints = nil
+	pa.streams = map[indexKey]*stream{}
+	pa.Unlock()
+
+	pa.devicesRefreshed()
+}
+
+// refreshDevices looks up all of the devices, and their streams, again.
+func (pa *PulseAudio) refreshDevices() {
+	log.Trace("Enter refreshDevices")
+	defer log.Trace("Exit refreshDevices")
+	pa.syncLock.Lock()
+	defer pa.syncLock.Unlock()
+
+	pa.syncDevices()
+	pa.devicesRefreshed()
+	pa.syncSessions()
+	pa.sessionsRefreshed()
+}
+
+// refreshSessions looks up the streams of all of the devices again.
+func (pa *PulseAudio) refreshSessions() {
+	log.Trace("Enter refreshSessions")
+	defer log.Trace("Exit refreshSessions")
+	pa.syncLock.Lock()
+	defer pa.syncLock.Unlock()
+
+	pa.syncSessions()
+	pa.sessionsRefreshed()
+}
+
+// devicesChanged picks up a change to the sinks, sources, or defaults. Most of these are volume changes, so the
+// callbacks are only called when there are new devices or some went away.
+func (pa *PulseAudio) devicesChanged() {
+	pa.syncLock.Lock()
+	defer pa.syncLock.Unlock()
+
+	if pa.syncDevices() {
+		pa.devicesRefreshed()
+		pa.syncSessions()
+		pa.sessionsRefreshed()
+	}
+}
+
+// sessionsChanged picks up a change to the streams.
+func (pa *PulseAudio) sessionsChanged() {
+	pa.syncLock.Lock()
+	defer pa.syncLock.Unlock()
+
+	if pa.syncSessions() {
+		pa.sessionsRefreshed()
+	}
+}
+
+// syncDevices brings the endpoints up to date with the server, publishing any volume changes that weren't made
+// by Automidically. It reports if there are new endpoints or some went away.
+// This expects the syncLock to be held already.
+func (pa *PulseAudio) syncDevices() bool {
+	c, since := pa.syncStart()
+	if c == nil {
+		return false
+	}
+	info, err := c.serverInfo()
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	infos := map[audio.Flow][]deviceInfo{}
+	for _, flow := range []audio.Flow{audio.Output, audio.Input} {
+		if infos[flow], err = c.devices(flow); err != nil {
+			log.Error(err)
+			return false
+		}
+	}
+
+	pa.Lock()
+	known := map[indexKey]*endpoint{}
+	for _, e := range pa.endpoints {
+		known[indexKey{flow: e.flow, index: e.index}] = e
+	}
+
+	changed := false
+	changes := []audio.VolumeChange{}
+	endpoints := []*endpoint{}
+	for _, flow := range []audio.Flow{audio.Output, audio.Input} {
+		for _, d := range infos[flow] {
+			if d.monitor {
+				continue
+			}
+			key := indexKey{flow: flow, index: d.index}
+			e, ok := known[key]
+			delete(known, key)
+			if !ok {
+				e = newEndpoint(pa, flow, d)
+				log.Debugf("found %s device named '%s'", flow, d.description)
+				changed = true
+			} else if e.update(d.volume, d.mute, since) {
+				changes = append(changes, e.describe(true))
+			}
+			endpoints = append(endpoints, e)
+		}
+	}
+	for _, e := range known {
+		e.gone = ErrorDeviceRemoved
+		changed = true
+	}
+	pa.endpoints = endpoints
+
+	previous := map[audio.Flow]string{audio.Output: pa.defaultSink, audio.Input: pa.defaultSource}
+	pa.defaultSink = info.defaultSink
+	pa.defaultSource = info.defaultSource
+	for _, e := range endpoints {
+		if e.isDefault() && previous[e.flow] != e.name {
+			log.Infof("using default %s device named: %s", e.flow, e.description)
+		}
+	}
+	pa.Unlock()
+
+	for _, change := range changes {
+		pa.queueVolumeChange(change)
+	}
+	return changed
+}
+
+// syncSessions brings the streams up to date with the server, publishing any volume changes that weren't made
+// by Automidically. It reports if there are new streams, some went away, or some moved to another device.
+// This expects the syncLock to be held already.
+func (pa *PulseAudio) syncSessions() bool {
+	c, since := pa.syncStart()
+	if c == nil {
+		return false
+	}
+	infos := map[audio.Flow][]streamInfo{}
+	for _, flow := range []audio.Flow{audio.Output, audio.Input} {
+		var err error
+		if infos[flow], err = c.streams(flow); err != nil {
+			log.Error(err)
+			return false
+		}
+	}
+
+	pa.Lock()
+	devices := map[indexKey]*endpoint{}
+	for _, e := range pa.endpoints {
+		devices[indexKey{flow: e.flow, index: e.index}] = e
+		e.sessions = nil
+	}
+
+	changed := false
+	changes := []audio.VolumeChange{}
+	streams := map[indexKey]*stream{}
+	for _, flow := range []audio.Flow{audio.Output, audio.Input} {
+		for _, info := range infos[flow] {
+			// Streams without a device are recording from a monitor, like the level meters of pavucontrol.
+			device, ok := devices[indexKey{flow: flow, index: info.device}]
+			if !ok || !info.hasVolume {
+				continue
+			}
+			key := indexKey{flow: flow, index: info.index}
+			s, ok := pa.streams[key]
+			if !ok {
+				s = newStream(pa, flow, info)
+				log.Tracef("discovered stream %s", s.executable)
+				changed = true
+			} else if s.device != device {
+				changed = true
+			}
+			s.device = device
+			if ok && s.update(info.volume, info.mute, since) {
+				changes = append(changes, s.describe(true))
+			}
+			device.sessions = append(device.sessions, s)
+			streams[key] = s
+		}
+	}
+	for key, s := range pa.streams {
+		if _, ok := streams[key]; !ok {
+			s.gone = audio.ErrorSessionExpired
+			changed = true
+		}
+	}
+	pa.streams = streams
+	pa.Unlock()
+
+	for _, change := range changes {
+		pa.queueVolumeChange(change)
+	}
+	return changed
+}
+
+// syncStart returns the connection to sync over, along with the count of changes before asking the server.
+func (pa *PulseAudio) syncStart() (*conn, uint64) {
+	pa.Lock()
+	defer pa.Unlock()
+	return pa.conn, pa.changes
+}
+
+// devicesRefreshed calls the OnDevicesRefreshed function.
+func (pa *PulseAudio) devicesRefreshed() {
+	pa.callbacksLock.Lock()
+	fn := pa.onDevicesRefreshed
+	pa.callbacksLock.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// sessionsRefreshed calls the OnSessionsRefreshed function with the streams of every device.
+func (pa *PulseAudio) sessionsRefreshed() {
+	pa.callbacksLock.Lock()
+	fn := pa.onSessionsRefreshed
+	pa.callbacksLock.Unlock()
+	if fn == nil {
+		return
+	}
+
+	pa.Lock()
+	endpoints := append([]*endpoint{}, pa.endpoints...)
+	sessions := make([][]audio.Session, len(endpoints))
+	for i, e := range endpoints {
+		sessions[i] = e.wrapSessions()
+	}
+	pa.Unlock()
+
+	for i, e := range endpoints {
+		fn(e, sessions[i])
+	}
+}
+
+func (pa *PulseAudio) queueVolumeChange(change audio.VolumeChange) {
+	select {
+	case pa.volumeChangesChannel <- change:
+	default:
+		log.Debug("too many volume changes at once, dropping one")
+	}
+}
+
+// volumeEventLoop publishes the volume changes to the subscribers.
+func (pa *PulseAudio) volumeEventLoop() {
+	log.Trace("Enter volumeEventLoop")
+	defer log.Trace("Exit volumeEventLoop")
+
+	for {
+		var change audio.VolumeChange
+		select {
+		case <-pa.cleanupChan:
+			return
+		case change = <-pa.volumeChangesChannel:
+		}
+		log.Tracef("%+v", change)
+
+		pa.callbacksLock.Lock()
+		fns := pa.subscribers
+		pa.callbacksLock.Unlock()
+		for _, fn := range fns {
+			fn(change)
+		}
+	}
+}
+
+// View calls fn with all of the devices. Devices & streams that go away while fn is running return errors
+// instead of changing something else, so there's nothing to hold onto.
+func (pa *PulseAudio) View(fn func(devices []audio.Device)) {
+	pa.Lock()
+	devices := make([]audio.Device, len(pa.endpoints))
+	for i, e := range pa.endpoints {
+		devices[i] = e
+	}
+	pa.Unlock()
+	fn(devices)
+}
+
+// SetDefaultDevice makes the sink or source with the name the default. PulseAudio doesn't have roles, so they're ignored.
+func (pa *PulseAudio) SetDefaultDevice(id string, roles []audio.Role) error {
+	var device *endpoint
+	pa.Lock()
+	for _, e := range pa.endpoints {
+		if e.name == id {
+			device = e
+		}
+	}
+	pa.Unlock()
+	if device == nil {
+		return fmt.Errorf("%w: %s", audio.ErrorDeviceNotFound, id)
+	}
+
+	command := uint32(commandSetDefaultSink)
+	if device.flow == audio.Input {
+		command = commandSetDefaultSource
+	}
+	return pa.request(command, func(w *tagWriter) {
+		w.putString(id)
+	})
+}
+
+// RefreshDevices looks up all of the devices, and their streams, again.
+func (pa *PulseAudio) RefreshDevices() {
+	pa.refreshDevicesChannel <- true
+}
+
+// RefreshSessions looks up the streams of all of the devices again.
+func (pa *PulseAudio) RefreshSessions() {
+	pa.refreshSessionsChannel <- true
+}
+
+// Subscribe calls fn with every VolumeChange from now on. The calls are made one at a time from the same
+// goroutine, so fn shouldn't take long.
+func (pa *PulseAudio) Subscribe(fn func(audio.VolumeChange)) {
+	pa.callbacksLock.Lock()
+	defer pa.callbacksLock.Unlock()
+	pa.subscribers = append(pa.subscribers, fn)
+}
+
+// OnSessionsRefreshed calls fn with all of the streams of a device each time they're looked up again.
+func (pa *PulseAudio) OnSessionsRefreshed(fn func(audio.Device, []audio.Session)) {
+	pa.callbacksLock.Lock()
+	defer pa.callbacksLock.Unlock()
+	pa.onSessionsRefreshed = fn
+}
+
+// OnDevicesRefreshed calls fn after the devices were looked up again.
+func (pa *PulseAudio) OnDevicesRefreshed(fn func()) {
+	pa.callbacksLock.Lock()
+	defer pa.callbacksLock.Unlock()
+	pa.onDevicesRefreshed = fn
+}
+
+// Cleanup is called by other packages to close down the event loops and the connection to get ready for shutdown.
+func (pa *PulseAudio) Cleanup() error {
+	if pa.cleanupChan != nil {
+		close(pa.cleanupChan)
+	}
+	pa.Lock()
+	defer pa.Unlock()
+	if pa.conn != nil {
+		pa.conn.close(ErrorConnectionClosed)
+		pa.conn = nil
+	}
+	return nil
+}
+
+// New connects to the PulseAudio server of the user and starts the event loops. The server is found the same way
+// PulseAudio's own clients find it, see https://www.freedesktop.org/wiki/Software/PulseAudio/Documentation/User/ServerStrings/
+func New() (*PulseAudio, error) {
+	path, err := socketPath()
+	if err != nil {
+		return nil, err
+	}
+
+	pa := &PulseAudio{
+		path:                   path,
+		streams:                map[indexKey]*stream{},
+		refreshDevicesChannel:  make(chan bool, 20),
+		refreshSessionsChannel: make(chan bool, 20),
+		devicesChangedChannel:  make(chan bool, 1),
+		sessionsChangedChannel: make(chan bool, 1),
+		volumeChangesChannel:   make(chan audio.VolumeChange, volumeChangesBuffer),
+		cleanupChan:            make(chan bool, 1),
+	}
+	if err := pa.connect(); err != nil {
+		return nil, err
+	}
+
+	go pa.eventLoop()
+	go pa.volumeEventLoop()
+	pa.refreshDevicesChannel <- true
+
+	return pa, nil
+}
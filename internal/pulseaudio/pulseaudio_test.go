@@ -0,0 +1,731 @@
+package pulseaudio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/GregoryDosh/automidically/internal/audio"
+)
+
+// The kinds of subscription events, along with the facility.
+const (
+	eventNew    = 0x00
+	eventChange = 0x10
+	eventRemove = 0x20
+)
+
+// These write the parts of the replies that are only skipped over by the client.
+
+func (w *tagWriter) putU8(v byte) {
+	w.WriteByte(tagU8)
+	w.WriteByte(v)
+}
+
+func (w *tagWriter) putUsec(v uint64) {
+	w.WriteByte(tagUsec)
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *tagWriter) putVolume(v uint32) {
+	w.WriteByte(tagVolume)
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *tagWriter) putSampleSpec() {
+	w.Write([]byte{tagSampleSpec, 3, 2, 0, 0, 0xac, 0x44})
+}
+
+func (w *tagWriter) putChannelMap(channels int) {
+	w.WriteByte(tagChannelMap)
+	w.WriteByte(byte(channels))
+	for i := 0; i < channels; i++ {
+		w.WriteByte(byte(i + 1))
+	}
+}
+
+func (w *tagWriter) putFormatInfo() {
+	w.WriteByte(tagFormatInfo)
+	w.putU8(1)
+	w.putProplist(nil)
+}
+
+type testDevice struct {
+	index       uint32
+	name        string
+	description string
+	volume      []uint32
+	mute        bool
+	// monitor is the sink a source is the monitor of, or the monitor source of a sink.
+	monitor uint32
+}
+
+type testStream struct {
+	index  uint32
+	device uint32
+	volume []uint32
+	mute   bool
+	props  map[string]string
+}
+
+// testServer answers the commands used by PulseAudio like a real server would, from the devices & streams it has.
+// Changes made through it send subscription events the same way too.
+type testServer struct {
+	t             *testing.T
+	sinks         []*testDevice
+	sources       []*testDevice
+	sinkInputs    []*testStream
+	defaultSink   string
+	defaultSource string
+	conn          net.Conn
+	writeLock     sync.Mutex
+	sync.Mutex
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	s := &testServer{
+		t: t,
+		sinks: []*testDevice{
+			{index: 0, name: "speakers", description: "Speakers", volume: []uint32{volumeNorm, volumeNorm / 2}, monitor: 1},
+			{index: 2, name: "hdmi", description: "HDMI", volume: []uint32{volumeNorm / 4, volumeNorm / 4}, monitor: 3},
+		},
+		sources: []*testDevice{
+			{index: 1, name: "speakers.monitor", description: "Monitor of Speakers", volume: []uint32{volumeNorm}, monitor: 0},
+			{index: 3, name: "hdmi.monitor", description: "Monitor of HDMI", volume: []uint32{volumeNorm}, monitor: 2},
+			{index: 4, name: "mic", description: "Microphone", volume: []uint32{volumeNorm / 2}, monitor: invalidIndex},
+		},
+		sinkInputs: []*testStream{
+			{index: 9, device: 0, volume: []uint32{volumeNorm, volumeNorm}, props: map[string]string{
+				"application.process.binary": "firefox",
+				"application.process.id":     "1",
+				"application.name":           "Firefox",
+			}},
+			{index: 10, device: 0, volume: []uint32{volumeNorm}, props: map[string]string{
+				"application.name": "System Sounds",
+				"media.role":       "event",
+			}},
+		},
+		defaultSink:   "speakers",
+		defaultSource: "mic",
+	}
+
+	path := filepath.Join(t.TempDir(), "native")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.writeLock.Lock()
+			s.conn = c
+			s.writeLock.Unlock()
+			go s.serve(c)
+		}
+	}()
+
+	previous, set := os.LookupEnv("PULSE_SERVER")
+	os.Setenv("PULSE_SERVER", "unix:"+path)
+	t.Cleanup(func() {
+		l.Close()
+		if set {
+			os.Setenv("PULSE_SERVER", previous)
+		} else {
+			os.Unsetenv("PULSE_SERVER")
+		}
+	})
+	return s
+}
+
+// send writes a packet to the client, the tag is eventTag for subscription events.
+func (s *testServer) send(command, tag uint32, args func(w *tagWriter)) {
+	w := &tagWriter{}
+	w.putU32(command)
+	w.putU32(tag)
+	if args != nil {
+		args(w)
+	}
+	descriptor := make([]byte, descriptorSize)
+	binary.BigEndian.PutUint32(descriptor, uint32(w.Len()))
+	binary.BigEndian.PutUint32(descriptor[4:], controlChannel)
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	if s.conn != nil {
+		s.conn.Write(append(descriptor, w.Bytes()...))
+	}
+}
+
+func (s *testServer) reply(tag uint32, args func(w *tagWriter)) {
+	s.send(commandReply, tag, args)
+}
+
+func (s *testServer) event(facility, kind, index uint32) {
+	s.send(commandSubscribeEvent, eventTag, func(w *tagWriter) {
+		w.putU32(facility | kind)
+		w.putU32(index)
+	})
+}
+
+func (s *testServer) serve(c net.Conn) {
+	descriptor := make([]byte, descriptorSize)
+	for {
+		if _, err := io.ReadFull(c, descriptor); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint32(descriptor))
+		if _, err := io.ReadFull(c, payload); err != nil {
+			return
+		}
+		r := &tagReader{b: payload}
+		command, tag := r.u32(), r.u32()
+		s.handle(command, tag, r)
+	}
+}
+
+func (s *testServer) handle(command, tag uint32, r *tagReader) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch command {
+	case commandAuth:
+		version := r.u32()
+		cookie := r.arbitrary()
+		if version != protocolVersion || len(cookie) != cookieSize || r.err != nil {
+			s.t.Errorf("bad auth: version %d, cookie of %d bytes, %v", version, len(cookie), r.err)
+		}
+		// The upper bits are the shared memory flags, which should be ignored.
+		s.reply(tag, func(w *tagWriter) { w.putU32(35 | 0x80000000) })
+	case commandSetClientName:
+		if props := r.proplist(); props["application.name"] != "Automidically" || r.err != nil {
+			s.t.Errorf("bad client name: %v %v", props, r.err)
+		}
+		s.reply(tag, func(w *tagWriter) { w.putU32(7) })
+	case commandSubscribe:
+		if mask := r.u32(); mask != subscriptionMask {
+			s.t.Errorf("subscribed to %x", mask)
+		}
+		s.reply(tag, nil)
+	case commandGetServerInfo:
+		s.reply(tag, func(w *tagWriter) {
+			w.putString("pulseaudio")
+			w.putString("15.0")
+			w.putString("user")
+			w.putString("host")
+			w.putSampleSpec()
+			w.putString(s.defaultSink)
+			w.putString(s.defaultSource)
+			w.putU32(1)
+			w.putChannelMap(2)
+		})
+	case commandGetSinkInfoList:
+		s.reply(tag, func(w *tagWriter) {
+			for _, d := range s.sinks {
+				writeDevice(w, d)
+			}
+		})
+	case commandGetSourceInfoList:
+		s.reply(tag, func(w *tagWriter) {
+			for _, d := range s.sources {
+				writeDevice(w, d)
+			}
+		})
+	case commandGetSinkInputInfoList:
+		s.reply(tag, func(w *tagWriter) {
+			for _, st := range s.sinkInputs {
+				writeSinkInput(w, st)
+			}
+		})
+	case commandGetSourceOutputInfoList:
+		s.reply(tag, nil)
+	case commandSetSinkVolume, commandSetSourceVolume, commandSetSinkMute, commandSetSourceMute:
+		index := r.u32()
+		if name := r.string(); name != "" {
+			s.t.Errorf("device should be set by index, got name %s", name)
+		}
+		devices, facility := s.sinks, uint32(facilitySink)
+		if command == commandSetSourceVolume || command == commandSetSourceMute {
+			devices, facility = s.sources, facilitySource
+		}
+		d := findDevice(devices, index)
+		if d == nil {
+			s.send(commandError, tag, func(w *tagWriter) { w.putU32(errorNoSuchEntity) })
+			return
+		}
+		if command == commandSetSinkVolume || command == commandSetSourceVolume {
+			d.volume = r.cVolume()
+		} else {
+			d.mute = r.bool()
+		}
+		if r.err != nil {
+			s.t.Error(r.err)
+		}
+		s.reply(tag, nil)
+		s.event(facility, eventChange, index)
+	case commandSetSinkInputVolume, commandSetSinkInputMute:
+		index := r.u32()
+		st := s.findSinkInput(index)
+		if st == nil {
+			s.send(commandError, tag, func(w *tagWriter) { w.putU32(errorNoSuchEntity) })
+			return
+		}
+		if command == commandSetSinkInputVolume {
+			st.volume = r.cVolume()
+		} else {
+			st.mute = r.bool()
+		}
+		if r.err != nil {
+			s.t.Error(r.err)
+		}
+		s.reply(tag, nil)
+		s.event(facilitySinkInput, eventChange, index)
+	case commandSetDefaultSink, commandSetDefaultSource:
+		name := r.string()
+		if command == commandSetDefaultSink {
+			s.defaultSink = name
+		} else {
+			s.defaultSource = name
+		}
+		s.reply(tag, nil)
+		s.event(facilityServer, eventChange, invalidIndex)
+	default:
+		s.t.Errorf("unexpected command %d", command)
+		s.send(commandError, tag, func(w *tagWriter) { w.putU32(2) })
+	}
+}
+
+func findDevice(devices []*testDevice, index uint32) *testDevice {
+	for _, d := range devices {
+		if d.index == index {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *testServer) findSinkInput(index uint32) *testStream {
+	for _, st := range s.sinkInputs {
+		if st.index == index {
+			return st
+		}
+	}
+	return nil
+}
+
+func writeDevice(w *tagWriter, d *testDevice) {
+	w.putU32(d.index)
+	w.putString(d.name)
+	w.putString(d.description)
+	w.putSampleSpec()
+	w.putChannelMap(len(d.volume))
+	w.putU32(0)
+	w.putCVolume(d.volume)
+	w.putBool(d.mute)
+	w.putU32(d.monitor)
+	w.putString("")
+	w.putUsec(0)
+	w.putString("module-alsa-card.c")
+	w.putU32(0)
+	w.putProplist(map[string]string{"device.description": d.description})
+	w.putUsec(0)
+	w.putVolume(volumeNorm)
+	w.putU32(0)
+	w.putU32(65537)
+	w.putU32(1)
+	w.putU32(1)
+	w.putString("analog-output")
+	w.putString("Analog Output")
+	w.putU32(1)
+	w.putU32(2)
+	w.putString("analog-output")
+	w.putU8(1)
+	w.putFormatInfo()
+}
+
+func writeSinkInput(w *tagWriter, st *testStream) {
+	w.putU32(st.index)
+	w.putString("Playback")
+	w.putU32(invalidIndex)
+	w.putU32(7)
+	w.putU32(st.device)
+	w.putSampleSpec()
+	w.putChannelMap(len(st.volume))
+	w.putCVolume(st.volume)
+	w.putUsec(0)
+	w.putUsec(0)
+	w.putString("speex-float-1")
+	w.putString("protocol-native.c")
+	w.putBool(st.mute)
+	w.putProplist(st.props)
+	w.putBool(false)
+	w.putBool(true)
+	w.putBool(true)
+	w.putFormatInfo()
+}
+
+// newTestPulseAudio connects to the test server and waits for the first look at the devices & streams.
+func newTestPulseAudio(t *testing.T, s *testServer) *PulseAudio {
+	t.Helper()
+	pa, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pa.Cleanup()
+	})
+	waitFor(t, "the devices & streams", func() bool {
+		return findEndpoint(pa, "speakers") != nil && len(findEndpoint(pa, "speakers").Sessions()) == 2
+	})
+	return pa
+}
+
+func findEndpoint(pa *PulseAudio, id string) audio.Device {
+	var found audio.Device
+	pa.View(func(devices []audio.Device) {
+		for _, d := range devices {
+			if d.ID() == id {
+				found = d
+			}
+		}
+	})
+	return found
+}
+
+func findSession(d audio.Device, executable string) audio.Session {
+	for _, s := range d.Sessions() {
+		if s.Executable() == executable {
+			return s
+		}
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, what string, ok func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !ok() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestTagstruct(t *testing.T) {
+	w := &tagWriter{}
+	w.putU32(42)
+	w.putBool(true)
+	w.putBool(false)
+	w.putString("hello")
+	w.putString("")
+	w.putArbitrary([]byte{1, 2, 3})
+	w.putCVolume([]uint32{volumeNorm, 0})
+	w.putProplist(map[string]string{"application.name": "Firefox"})
+	w.putSampleSpec()
+	w.putChannelMap(2)
+	w.putU8(7)
+	w.putUsec(1000)
+	w.putVolume(volumeNorm)
+	w.putFormatInfo()
+
+	r := &tagReader{b: w.Bytes()}
+	if v := r.u32(); v != 42 {
+		t.Errorf("u32 = %d", v)
+	}
+	if !r.bool() || r.bool() {
+		t.Error("booleans didn't come back the same")
+	}
+	if v := r.string(); v != "hello" {
+		t.Errorf("string = %s", v)
+	}
+	if v := r.string(); v != "" {
+		t.Errorf("null string = %s", v)
+	}
+	if v := r.arbitrary(); !reflect.DeepEqual(v, []byte{1, 2, 3}) {
+		t.Errorf("arbitrary = %v", v)
+	}
+	if v := r.cVolume(); !reflect.DeepEqual(v, []uint32{volumeNorm, 0}) {
+		t.Errorf("cvolume = %v", v)
+	}
+	if v := r.proplist(); !reflect.DeepEqual(v, map[string]string{"application.name": "Firefox"}) {
+		t.Errorf("proplist = %v", v)
+	}
+	r.sampleSpec()
+	r.channelMap()
+	if v := r.u8(); v != 7 {
+		t.Errorf("u8 = %d", v)
+	}
+	if v := r.usec(); v != 1000 {
+		t.Errorf("usec = %d", v)
+	}
+	if v := r.volume(); v != volumeNorm {
+		t.Errorf("volume = %d", v)
+	}
+	r.formatInfo()
+	if r.err != nil || !r.done() {
+		t.Errorf("expected everything to be read, err %v with %d bytes left", r.err, len(r.b))
+	}
+}
+
+func TestTagstructMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		read func(r *tagReader)
+	}{
+		{"wrong tag", []byte{tagString, 'a', 0}, func(r *tagReader) { r.u32() }},
+		{"too short", []byte{tagU32, 0, 0}, func(r *tagReader) { r.u32() }},
+		{"unterminated string", []byte{tagString, 'a', 'b'}, func(r *tagReader) { r.string() }},
+		{"short arbitrary", []byte{tagArbitrary, 0, 0, 0, 5, 1}, func(r *tagReader) { r.arbitrary() }},
+		{"short cvolume", []byte{tagCVolume, 2, 0, 1, 0, 0}, func(r *tagReader) { r.cVolume() }},
+		{"not a boolean", []byte{'x'}, func(r *tagReader) { r.bool() }},
+		{"empty", nil, func(r *tagReader) { r.u32() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &tagReader{b: tt.b}
+			tt.read(r)
+			if !errors.Is(r.err, ErrorMalformedPacket) {
+				t.Errorf("expected %s, got %v", ErrorMalformedPacket, r.err)
+			}
+			// Everything after the first problem is the zero value.
+			if v := r.u32(); v != 0 {
+				t.Errorf("read %d after an error", v)
+			}
+		})
+	}
+}
+
+func TestScaleVolume(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes []uint32
+		v       float32
+		expect  []uint32
+	}{
+		{"same", []uint32{volumeNorm, volumeNorm}, 0.5, []uint32{volumeNorm / 2, volumeNorm / 2}},
+		{"balance", []uint32{volumeNorm, volumeNorm / 2}, 0.5, []uint32{volumeNorm / 2, volumeNorm / 4}},
+		{"balance from quiet", []uint32{volumeNorm / 4, volumeNorm / 8}, 1, []uint32{volumeNorm, volumeNorm / 2}},
+		{"silent", []uint32{0, 0}, 0.5, []uint32{volumeNorm / 2, volumeNorm / 2}},
+		{"mono", []uint32{volumeNorm}, 0, []uint32{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if v := scaleVolume(tt.volumes, channelBalance(tt.volumes), tt.v); !reflect.DeepEqual(v, tt.expect) {
+				t.Errorf("got %v, expected %v", v, tt.expect)
+			}
+		})
+	}
+}
+
+func TestPulseAudioDevices(t *testing.T) {
+	s := newTestServer(t)
+	pa := newTestPulseAudio(t, s)
+
+	tests := []struct {
+		id        string
+		name      string
+		flow      audio.Flow
+		isDefault bool
+		volume    float32
+	}{
+		{"speakers", "Speakers", audio.Output, true, 1},
+		{"hdmi", "HDMI", audio.Output, false, 0.25},
+		{"mic", "Microphone", audio.Input, true, 0.5},
+	}
+	devices := 0
+	pa.View(func(all []audio.Device) { devices = len(all) })
+	if devices != len(tests) {
+		t.Errorf("found %d devices, expected %d without the monitors", devices, len(tests))
+	}
+	for _, tt := range tests {
+		d := findEndpoint(pa, tt.id)
+		if d == nil {
+			t.Errorf("device %s not found", tt.id)
+			continue
+		}
+		if d.Name() != tt.name || d.Flow() != tt.flow || d.IsDefault(audio.Console) != tt.isDefault {
+			t.Errorf("device %s is %s %s default %t", tt.id, d.Name(), d.Flow(), d.IsDefault(audio.Console))
+		}
+		if v, err := d.GetVolumeLevel(); err != nil || v != tt.volume {
+			t.Errorf("device %s volume %f %v, expected %f", tt.id, v, err, tt.volume)
+		}
+	}
+
+	speakers := findEndpoint(pa, "speakers")
+	firefox := findSession(speakers, "firefox")
+	if firefox == nil {
+		t.Fatal("firefox stream not found")
+	}
+	if firefox.Pid() != 1 || firefox.DisplayName() != "Firefox" || firefox.IsSystem() {
+		t.Errorf("firefox stream is pid %d %s system %t", firefox.Pid(), firefox.DisplayName(), firefox.IsSystem())
+	}
+	system := findSession(speakers, "")
+	if system == nil || !system.IsSystem() {
+		t.Error("the event sounds should be the system session")
+	}
+	if sessions := findEndpoint(pa, "hdmi").Sessions(); len(sessions) != 0 {
+		t.Errorf("hdmi should have no streams, found %d", len(sessions))
+	}
+}
+
+func TestPulseAudioEvents(t *testing.T) {
+	s := newTestServer(t)
+	pa := newTestPulseAudio(t, s)
+	changes := make(chan audio.VolumeChange, 100)
+	pa.Subscribe(func(c audio.VolumeChange) { changes <- c })
+	speakers := findEndpoint(pa, "speakers")
+	firefox := findSession(speakers, "firefox")
+
+	// A change made in pavucontrol comes in as an event.
+	s.Lock()
+	s.findSinkInput(9).volume = []uint32{volumeNorm / 2, volumeNorm / 4}
+	s.findSinkInput(9).mute = true
+	s.Unlock()
+	s.event(facilitySinkInput, eventChange, 9)
+	waitFor(t, "the stream volume", func() bool {
+		v, _ := firefox.GetVolumeLevel()
+		m, _ := firefox.GetMute()
+		return v == 0.5 && m
+	})
+	select {
+	case c := <-changes:
+		if !c.External || c.Session != "firefox" || c.Volume != 0.5 || !c.Mute || c.DeviceID != "speakers" {
+			t.Errorf("unexpected change %+v", c)
+		}
+	case <-time.After(time.Second):
+		t.Error("the change wasn't published")
+	}
+
+	// Sinks changing volume too.
+	s.Lock()
+	s.sinks[1].volume = []uint32{volumeNorm, volumeNorm}
+	s.Unlock()
+	s.event(facilitySink, eventChange, 2)
+	waitFor(t, "the hdmi volume", func() bool {
+		v, _ := findEndpoint(pa, "hdmi").GetVolumeLevel()
+		return v == 1
+	})
+
+	// New streams show up, on whichever sink they're playing.
+	s.Lock()
+	s.sinkInputs = append(s.sinkInputs, &testStream{index: 11, device: 2, volume: []uint32{volumeNorm}, props: map[string]string{
+		"application.process.binary": "mpv",
+		"application.name":           "mpv",
+	}})
+	s.Unlock()
+	s.event(facilitySinkInput, eventNew, 11)
+	waitFor(t, "the new stream", func() bool {
+		return findSession(findEndpoint(pa, "hdmi"), "mpv") != nil
+	})
+
+	// Removed streams are gone, and using them says so.
+	s.Lock()
+	s.sinkInputs = s.sinkInputs[1:]
+	s.Unlock()
+	s.event(facilitySinkInput, eventRemove, 9)
+	waitFor(t, "the stream to be removed", func() bool {
+		return findSession(speakers, "firefox") == nil
+	})
+	if _, err := firefox.GetVolumeLevel(); !errors.Is(err, audio.ErrorSessionExpired) {
+		t.Errorf("expected %s, got %v", audio.ErrorSessionExpired, err)
+	}
+
+	// The default changing comes in as a server event.
+	s.Lock()
+	s.defaultSink = "hdmi"
+	s.Unlock()
+	s.event(facilityServer, eventChange, invalidIndex)
+	waitFor(t, "hdmi to be the default", func() bool {
+		return findEndpoint(pa, "hdmi").IsDefault(audio.Console) && !speakers.IsDefault(audio.Console)
+	})
+}
+
+func TestPulseAudioCommands(t *testing.T) {
+	s := newTestServer(t)
+	pa := newTestPulseAudio(t, s)
+	speakers := findEndpoint(pa, "speakers")
+	mic := findEndpoint(pa, "mic")
+	firefox := findSession(speakers, "firefox")
+	serverState := func(fn func()) {
+		s.Lock()
+		defer s.Unlock()
+		fn()
+	}
+
+	// The balance of the channels is kept, even after going all of the way down.
+	for _, step := range []struct {
+		v      float32
+		expect []uint32
+	}{
+		{0.5, []uint32{volumeNorm / 2, volumeNorm / 4}},
+		{0, []uint32{0, 0}},
+		{1, []uint32{volumeNorm, volumeNorm / 2}},
+	} {
+		if err := speakers.SetVolumeLevel(step.v); err != nil {
+			t.Fatal(err)
+		}
+		serverState(func() {
+			if !reflect.DeepEqual(s.sinks[0].volume, step.expect) {
+				t.Errorf("speakers at %f are %v, expected %v", step.v, s.sinks[0].volume, step.expect)
+			}
+		})
+		if v, _ := speakers.GetVolumeLevel(); v != step.v {
+			t.Errorf("speakers volume is %f, expected %f", v, step.v)
+		}
+	}
+	if err := speakers.SetVolumeLevel(2); err == nil {
+		t.Error("volumes past 1 should be refused")
+	}
+
+	if err := firefox.SetVolumeLevel(0.25); err != nil {
+		t.Fatal(err)
+	}
+	if err := firefox.SetMute(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := mic.SetMute(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := mic.SetVolumeLevel(1); err != nil {
+		t.Fatal(err)
+	}
+	serverState(func() {
+		st := s.findSinkInput(9)
+		if !reflect.DeepEqual(st.volume, []uint32{volumeNorm / 4, volumeNorm / 4}) || !st.mute {
+			t.Errorf("firefox is %v muted %t", st.volume, st.mute)
+		}
+		if m := findDevice(s.sources, 4); !m.mute || !reflect.DeepEqual(m.volume, []uint32{volumeNorm}) {
+			t.Errorf("mic is %v muted %t", m.volume, m.mute)
+		}
+	})
+	if m, _ := firefox.GetMute(); !m {
+		t.Error("firefox should be muted")
+	}
+
+	if err := pa.SetDefaultDevice("hdmi", []audio.Role{audio.Console}); err != nil {
+		t.Fatal(err)
+	}
+	serverState(func() {
+		if s.defaultSink != "hdmi" || s.defaultSource != "mic" {
+			t.Errorf("defaults are %s & %s", s.defaultSink, s.defaultSource)
+		}
+	})
+	waitFor(t, "hdmi to be the default", func() bool {
+		return findEndpoint(pa, "hdmi").IsDefault(audio.Console)
+	})
+	if err := pa.SetDefaultDevice("missing", nil); !errors.Is(err, audio.ErrorDeviceNotFound) {
+		t.Errorf("expected %s, got %v", audio.ErrorDeviceNotFound, err)
+	}
+}
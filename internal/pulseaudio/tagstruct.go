@@ -0,0 +1,275 @@
+package pulseaudio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// The types of values in a tagstruct, every value is prefixed by one of these.
+// https://gitlab.freedesktop.org/pulseaudio/pulseaudio/-/blob/master/src/pulsecore/tagstruct.h
+const (
+	tagString       = 't'
+	tagStringNull   = 'N'
+	tagU32          = 'L'
+	tagU8           = 'B'
+	tagU64          = 'R'
+	tagS64          = 'r'
+	tagSampleSpec   = 'a'
+	tagArbitrary    = 'x'
+	tagBooleanTrue  = '1'
+	tagBooleanFalse = '0'
+	tagTimeval      = 'T'
+	tagUsec         = 'U'
+	tagChannelMap   = 'm'
+	tagCVolume      = 'v'
+	tagProplist     = 'P'
+	tagVolume       = 'V'
+	tagFormatInfo   = 'f'
+)
+
+// tagWriter builds up the tagstruct of a command.
+type tagWriter struct {
+	bytes.Buffer
+}
+
+func (w *tagWriter) putU32(v uint32) {
+	w.WriteByte(tagU32)
+	binary.Write(w, binary.BigEndian, v)
+}
+
+func (w *tagWriter) putBool(v bool) {
+	if v {
+		w.WriteByte(tagBooleanTrue)
+	} else {
+		w.WriteByte(tagBooleanFalse)
+	}
+}
+
+// putString writes s, an empty string is sent as a null string which is how PulseAudio says "not this one".
+func (w *tagWriter) putString(s string) {
+	if s == "" {
+		w.WriteByte(tagStringNull)
+		return
+	}
+	w.WriteByte(tagString)
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+func (w *tagWriter) putArbitrary(b []byte) {
+	w.WriteByte(tagArbitrary)
+	binary.Write(w, binary.BigEndian, uint32(len(b)))
+	w.Write(b)
+}
+
+func (w *tagWriter) putCVolume(volumes []uint32) {
+	w.WriteByte(tagCVolume)
+	w.WriteByte(byte(len(volumes)))
+	for _, v := range volumes {
+		binary.Write(w, binary.BigEndian, v)
+	}
+}
+
+// putProplist writes the properties, the values are sent null terminated like PulseAudio's own clients do.
+func (w *tagWriter) putProplist(props map[string]string) {
+	w.WriteByte(tagProplist)
+	for k, v := range props {
+		w.putString(k)
+		value := append([]byte(v), 0)
+		w.putU32(uint32(len(value)))
+		w.putArbitrary(value)
+	}
+	w.WriteByte(tagStringNull)
+}
+
+// tagReader reads the values out of the tagstruct of a reply or event. Once something goes wrong every read
+// after it returns the zero value, so the error only has to be checked at the end.
+type tagReader struct {
+	b   []byte
+	err error
+}
+
+func (r *tagReader) fail(format string, args ...interface{}) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %s", ErrorMalformedPacket, fmt.Sprintf(format, args...))
+	}
+	r.b = nil
+}
+
+// done reports if everything has been read, lists of sinks & such just go until the end of the tagstruct.
+func (r *tagReader) done() bool {
+	return r.err != nil || len(r.b) == 0
+}
+
+// next returns n bytes after checking for the expected tag.
+func (r *tagReader) next(tag byte, n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < 1+n {
+		r.fail("expected %c but it's too short", tag)
+		return nil
+	}
+	if r.b[0] != tag {
+		r.fail("expected %c but found %c", tag, r.b[0])
+		return nil
+	}
+	v := r.b[1 : 1+n]
+	r.b = r.b[1+n:]
+	return v
+}
+
+func (r *tagReader) u32() uint32 {
+	if v := r.next(tagU32, 4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (r *tagReader) u8() uint8 {
+	if v := r.next(tagU8, 1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (r *tagReader) usec() uint64 {
+	if v := r.next(tagUsec, 8); v != nil {
+		return binary.BigEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (r *tagReader) volume() uint32 {
+	if v := r.next(tagVolume, 4); v != nil {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (r *tagReader) bool() bool {
+	if r.err != nil {
+		return false
+	}
+	if len(r.b) < 1 {
+		r.fail("expected a boolean but it's too short")
+		return false
+	}
+	switch r.b[0] {
+	case tagBooleanTrue:
+		r.b = r.b[1:]
+		return true
+	case tagBooleanFalse:
+		r.b = r.b[1:]
+		return false
+	}
+	r.fail("expected a boolean but found %c", r.b[0])
+	return false
+}
+
+// string reads a string, a null string comes back empty.
+func (r *tagReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	if len(r.b) >= 1 && r.b[0] == tagStringNull {
+		r.b = r.b[1:]
+		return ""
+	}
+	if len(r.b) < 1 || r.b[0] != tagString {
+		r.fail("expected a string")
+		return ""
+	}
+	end := bytes.IndexByte(r.b[1:], 0)
+	if end < 0 {
+		r.fail("string isn't terminated")
+		return ""
+	}
+	s := string(r.b[1 : 1+end])
+	r.b = r.b[2+end:]
+	return s
+}
+
+func (r *tagReader) arbitrary() []byte {
+	size := r.next(tagArbitrary, 4)
+	if size == nil {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint32(size))
+	if len(r.b) < n {
+		r.fail("arbitrary data is too short")
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+// sampleSpec skips over a sample spec, the format & rate of a stream don't matter for the volume.
+func (r *tagReader) sampleSpec() {
+	r.next(tagSampleSpec, 6)
+}
+
+// channelMap skips over a channel map.
+func (r *tagReader) channelMap() {
+	if v := r.next(tagChannelMap, 1); v != nil {
+		r.skip(int(v[0]))
+	}
+}
+
+// cVolume reads the volume of each channel.
+func (r *tagReader) cVolume() []uint32 {
+	v := r.next(tagCVolume, 1)
+	if v == nil {
+		return nil
+	}
+	n := int(v[0])
+	if len(r.b) < 4*n {
+		r.fail("channel volumes are too short")
+		return nil
+	}
+	volumes := make([]uint32, n)
+	for i := range volumes {
+		volumes[i] = binary.BigEndian.Uint32(r.b[4*i:])
+	}
+	r.b = r.b[4*n:]
+	return volumes
+}
+
+// proplist reads the properties, the values are usually null terminated strings so the null is taken off.
+func (r *tagReader) proplist() map[string]string {
+	props := map[string]string{}
+	r.next(tagProplist, 0)
+	for r.err == nil {
+		k := r.string()
+		if k == "" {
+			break
+		}
+		n := r.u32()
+		v := r.arbitrary()
+		if r.err == nil && int(n) != len(v) {
+			r.fail("property %s has the wrong length", k)
+		}
+		props[k] = string(bytes.TrimRight(v, "\x00"))
+	}
+	return props
+}
+
+// formatInfo skips over a format info.
+func (r *tagReader) formatInfo() {
+	r.next(tagFormatInfo, 0)
+	r.u8()
+	r.proplist()
+}
+
+func (r *tagReader) skip(n int) {
+	if r.err != nil {
+		return
+	}
+	if len(r.b) < n {
+		r.fail("expected %d more bytes", n)
+		return
+	}
+	r.b = r.b[n:]
+}
@@ -2,12 +2,11 @@ package shell
 
 import (
 	"bytes"
-	"os/exec"
 	"strings"
-	"syscall"
 	"text/template"
 
 	"github.com/GregoryDosh/automidically/internal/activewindow"
+	"github.com/GregoryDosh/automidically/internal/midi/message"
 	sysmsg "github.com/GregoryDosh/automidically/internal/systray"
 	"github.com/sirupsen/logrus"
 )
@@ -18,19 +17,21 @@ var (
 )
 
 type Mapping struct {
-	Cc             int      `yaml:"cc"`
-	Command        []string `yaml:"-"`
-	LogOutput      bool     `yaml:"logOutput"`
-	SuppressErrors bool     `yaml:"suppressErrors"`
-	UsePowershell  bool     `yaml:"usePowershell"`
-	IsTemplate     bool     `yaml:"template"`
-	template       *template.Template
+	message.Trigger `yaml:",inline"`
+	Command         []string `yaml:"-"`
+	LogOutput       bool     `yaml:"logOutput"`
+	SuppressErrors  bool     `yaml:"suppressErrors"`
+	UsePowershell   bool     `yaml:"usePowershell"`
+	IsTemplate      bool     `yaml:"template"`
+	template        *template.Template
 }
 
 func (m *Mapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// This is so we can set some default values if not specified in the config.
 	type rawMapping Mapping
-	var raw rawMapping
+	raw := rawMapping{
+		Trigger: message.DefaultTrigger(),
+	}
 	if err := unmarshal(&raw); err != nil {
 		return err
 	}
@@ -50,7 +51,7 @@ func (m *Mapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 	// If this is supposed to be a template, parse it now.
 	if m.IsTemplate {
-		t, err := template.New("").Parse(strings.Join(m.Command, "\r\n"))
+		t, err := template.New("").Parse(strings.Join(m.Command, lineSeparator))
 		if err != nil {
 			return err
 		}
@@ -60,27 +61,26 @@ func (m *Mapping) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func (m *Mapping) HandleMIDIMessage(c int, v int) {
-	if m.Cc != c {
-		return
-	}
+func (m *Mapping) Validate() error {
+	return m.Trigger.Validate()
+}
 
-	exe := "cmd.exe"
-	args := []string{"/C"}
-	if m.UsePowershell {
-		exe = "powershell.exe"
-		args = []string{"-NoProfile", "-NonInteractive"}
+func (m *Mapping) HandleMIDIMessage(msg message.Message) {
+	if !m.Matches(msg) {
+		return
 	}
 
-	if m.template == nil {
-		args = append(args, m.Command...)
-	} else {
+	args := m.Command
+	if m.template != nil {
 		composed, err := templateToString(m.template, struct {
 			CC              int
 			Value           int
+			Type            string
+			Channel         int
+			Number          int
 			ProcessID       int
 			ProcessFilename string
-		}{c, v, aw.ProcessID(), aw.ProcessFilename()})
+		}{msg.Number, msg.Value, msg.Kind.String(), msg.Channel, msg.Number, aw.ProcessID(), aw.ProcessFilename()})
 		if err != nil {
 			log.Error(err)
 			return
@@ -88,12 +88,10 @@ func (m *Mapping) HandleMIDIMessage(c int, v int) {
 		if strings.TrimSpace(composed) == "" {
 			return
 		}
-		args = append(args, composed)
+		args = []string{composed}
 	}
 
-	cmd := exec.Command(exe, args...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
-
+	cmd := command(m.UsePowershell, args)
 	output, err := cmd.CombinedOutput()
 	if err != nil && !m.SuppressErrors {
 		log.Errorf("%s returned error %s", m.Command, err)
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package shell
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// lineSeparator joins the lines of a template command.
+const lineSeparator = "\n"
+
+// command runs args with /bin/sh, or pwsh for PowerShell Core. The shell takes the whole command as one
+// string, so the parts of it are joined with spaces like cmd.exe does.
+func command(usePowershell bool, args []string) *exec.Cmd {
+	script := strings.Join(args, " ")
+	if usePowershell {
+		return exec.Command("pwsh", "-NoProfile", "-NonInteractive", "-Command", script)
+	}
+	return exec.Command("/bin/sh", "-c", script)
+}
@@ -0,0 +1,23 @@
+package shell
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// lineSeparator joins the lines of a template command.
+const lineSeparator = "\r\n"
+
+// command runs args with cmd.exe, or powershell.exe, without flashing a console window.
+func command(usePowershell bool, args []string) *exec.Cmd {
+	exe := "cmd.exe"
+	prefix := []string{"/C"}
+	if usePowershell {
+		exe = "powershell.exe"
+		prefix = []string{"-NoProfile", "-NonInteractive"}
+	}
+
+	cmd := exec.Command(exe, append(prefix, args...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	return cmd
+}
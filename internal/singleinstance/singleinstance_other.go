@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package singleinstance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile stays open for as long as the process runs, the kernel drops the flock when it exits or crashes.
+var lockFile *os.File
+
+// getLock takes an flock on a file in the runtime directory of the user, so it's one instance per user the same
+// as the Local\ mutex on Windows. Without a runtime directory the file goes in the shared temp directory, so the
+// uid is part of the name and the file has to belong to the user, otherwise someone else could make it first.
+func getLock() error {
+	name := "automidically-" + lockName + ".lock"
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+		name = fmt.Sprintf("automidically-%d-%s.lock", os.Getuid(), lockName)
+	}
+	path := filepath.Join(dir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != os.Getuid() || !info.Mode().IsRegular() {
+		f.Close()
+		return fmt.Errorf("lock file %s isn't a file belonging to this user", path)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return InstanceAlreadyExistsError
+		}
+		return err
+	}
+	lockFile = f
+	return nil
+}
@@ -0,0 +1,75 @@
+//go:build !windows
+// +build !windows
+
+package singleinstance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setEnv sets an environment variable for the test, putting it back afterwards.
+func setEnv(t *testing.T, key, value string) {
+	previous, set := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if set {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func releaseLock(t *testing.T) {
+	t.Cleanup(func() {
+		if lockFile != nil {
+			lockFile.Close()
+			lockFile = nil
+		}
+	})
+}
+
+func TestGetLockTempDir(t *testing.T) {
+	dir := t.TempDir()
+	setEnv(t, "XDG_RUNTIME_DIR", "")
+	setEnv(t, "TMPDIR", dir)
+	releaseLock(t)
+
+	if err := getLock(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("automidically-%d-%s.lock", os.Getuid(), lockName))
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the lock file to have the uid in its name: %s", err)
+	}
+	// A separate open of the same file can't take the lock while it's held.
+	held := lockFile
+	lockFile = nil
+	defer held.Close()
+	if err := getLock(); !errors.Is(err, InstanceAlreadyExistsError) {
+		t.Errorf("expected %s, got %v", InstanceAlreadyExistsError, err)
+	}
+}
+
+func TestGetLockSymlink(t *testing.T) {
+	dir := t.TempDir()
+	setEnv(t, "XDG_RUNTIME_DIR", "")
+	setEnv(t, "TMPDIR", dir)
+	releaseLock(t)
+
+	target := filepath.Join(dir, "somewhere-else")
+	path := filepath.Join(dir, fmt.Sprintf("automidically-%d-%s.lock", os.Getuid(), lockName))
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := getLock(); err == nil {
+		t.Error("a symlink in place of the lock file shouldn't be followed")
+	}
+	if _, err := os.Stat(target); err == nil {
+		t.Error("the symlink target shouldn't have been created")
+	}
+}
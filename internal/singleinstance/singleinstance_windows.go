@@ -0,0 +1,22 @@
+package singleinstance
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+func getLock() error {
+	// https://docs.microsoft.com/en-us/windows/win32/api/synchapi/nf-synchapi-createmutexexw
+	name, err := syscall.UTF16PtrFromString("Local\\" + lockName)
+	if err != nil {
+		return err
+	}
+
+	var flags uint32 = 0x00000001
+	var desiredAccess uint32 = 0x00000000
+	if _, err := windows.CreateMutexEx(nil, name, flags, desiredAccess); err != nil {
+		return InstanceAlreadyExistsError
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !headless
+// +build !headless
+
+package systray
+
+import (
+	"github.com/lxn/walk"
+)
+
+func copyToClipboard(text string) error {
+	return walk.Clipboard().SetText(text)
+}
@@ -8,3 +8,9 @@ const (
 	SystrayRefreshSessions
 	SystrayQuit
 )
+
+// AudioDevice is an audio device to show in the menu, Flow is whether it's an output or input.
+type AudioDevice struct {
+	Name string
+	Flow string
+}
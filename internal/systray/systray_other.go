@@ -0,0 +1,15 @@
+//go:build !windows || headless
+// +build !windows headless
+
+package systray
+
+// There's only a systray on Windows, and headless builds leave it out so they don't need the systray library.
+// These do nothing so the rest of the packages don't have to check.
+
+func SetAudioDevices(devices []AudioDevice) {}
+
+func SetOutputVolume(name string, volume float32, mute bool) {}
+
+func SetMIDIDeviceStatus(name string, connected bool) {}
+
+func RemoveMIDIDevice(name string) {}
@@ -0,0 +1,167 @@
+//go:build !headless
+// +build !headless
+
+package systray
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/GregoryDosh/automidically/internal/icon"
+	"github.com/getlantern/systray"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	log            = logrus.WithField("function", "systray")
+	smAudioDevices = map[string]*systray.MenuItem{}
+	mAudioDevices  *systray.MenuItem
+	smMIDIDevices  = map[string]*systray.MenuItem{}
+	mMIDIDevices   *systray.MenuItem
+	midiStatus     = map[string]bool{}
+	midiLock       sync.Mutex
+)
+
+func Start(messageHandler func(Message)) func() {
+
+	return func() {
+		log.Trace("Enter systrayStart")
+		defer log.Trace("Exit systrayStart")
+
+		systray.SetIcon(icon.Main)
+		systray.SetTitle("AutoMIDIcally")
+		systray.SetTooltip("AutoMIDIcally")
+
+		mMIDIDevices = systray.AddMenuItem("MIDI Devices", "List of configured MIDI devices.")
+		mAudioDevices = systray.AddMenuItem("Audio Devices", "List of detected audio devices.")
+		refreshMIDIDevices()
+		mReload := systray.AddMenuItem("Reload", "Manual Reload")
+		mReloadConfig := mReload.AddSubMenuItem("Config", "Manual reload config.yml")
+		mReloadDevices := mReload.AddSubMenuItem("Devices", "Manual reload hardware devices")
+		mReloadSessions := mReload.AddSubMenuItem("Sessions", "Manual reload audio sessions")
+
+		systray.AddSeparator()
+		mQuit := systray.AddMenuItem("Quit", "Quit AutoMIDIcally")
+
+		sigintc := make(chan os.Signal, 1)
+		signal.Notify(sigintc, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			defer systray.Quit()
+			defer log.Debug("quitting systray")
+			for {
+				select {
+				case <-mReloadConfig.ClickedCh:
+					messageHandler(SystrayRefreshConfig)
+				case <-mReloadDevices.ClickedCh:
+					messageHandler(SystrayRefreshDevices)
+				case <-mReloadSessions.ClickedCh:
+					messageHandler(SystrayRefreshSessions)
+				case <-sigintc:
+					messageHandler(SystrayQuit)
+					return
+				case <-mQuit.ClickedCh:
+					messageHandler(SystrayQuit)
+					return
+				}
+			}
+		}()
+	}
+
+}
+
+func SetAudioDevices(devices []AudioDevice) {
+	// The systray hasn't started yet, or isn't going to when running headless.
+	if mAudioDevices == nil {
+		log.Debug("unable to set audio devices")
+		return
+	}
+	// This is kind of hacky, but since there isn't a way to remove menu items we have to hide them instead.
+	// This is maybe not that bad for audio devices since this list shoudn't change a whole lot in cardinality
+	// over time. But it still feels wrong :-\
+	// https://github.com/getlantern/systray/issues/54
+	for _, menuItem := range smAudioDevices {
+		menuItem.Hide()
+	}
+
+	for _, d := range devices {
+		title := fmt.Sprintf("%s [%s]", d.Name, d.Flow)
+		if device, ok := smAudioDevices[title]; ok {
+			device.Show()
+			continue
+		}
+		menuItem := mAudioDevices.AddSubMenuItem(title, "Click to copy name to clipboard.")
+		smAudioDevices[title] = menuItem
+
+		go audioDeviceClickHandler(menuItem, d.Name)
+	}
+}
+
+// SetOutputVolume shows the volume of the default output device in the tooltip.
+func SetOutputVolume(name string, volume float32, mute bool) {
+	if mAudioDevices == nil {
+		return
+	}
+	level := fmt.Sprintf("%.0f%%", volume*100)
+	if mute {
+		level = "muted"
+	}
+	systray.SetTooltip(fmt.Sprintf("AutoMIDIcally - %s: %s", name, level))
+}
+
+func audioDeviceClickHandler(m *systray.MenuItem, name string) {
+	for range m.ClickedCh {
+		if err := copyToClipboard(name); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// SetMIDIDeviceStatus shows if the configured MIDI device is connected in the menu.
+// This can be called before the systray has started, and it'll be shown once it has.
+func SetMIDIDeviceStatus(name string, connected bool) {
+	midiLock.Lock()
+	midiStatus[name] = connected
+	midiLock.Unlock()
+	refreshMIDIDevices()
+}
+
+// RemoveMIDIDevice hides a MIDI device that's no longer configured from the menu.
+func RemoveMIDIDevice(name string) {
+	midiLock.Lock()
+	delete(midiStatus, name)
+	midiLock.Unlock()
+	refreshMIDIDevices()
+}
+
+func refreshMIDIDevices() {
+	midiLock.Lock()
+	defer midiLock.Unlock()
+	if mMIDIDevices == nil {
+		return
+	}
+
+	for name, menuItem := range smMIDIDevices {
+		if _, ok := midiStatus[name]; !ok {
+			menuItem.Hide()
+		}
+	}
+
+	for name, connected := range midiStatus {
+		title := fmt.Sprintf("%s (disconnected)", name)
+		if connected {
+			title = fmt.Sprintf("%s (connected)", name)
+		}
+		menuItem, ok := smMIDIDevices[name]
+		if !ok {
+			menuItem = mMIDIDevices.AddSubMenuItem(title, "Connection status of the MIDI device.")
+			menuItem.Disable()
+			smMIDIDevices[name] = menuItem
+		}
+		menuItem.SetTitle(title)
+		menuItem.Show()
+	}
+}
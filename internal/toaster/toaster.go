@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 
-	"github.com/go-toast/toast"
 	"github.com/sirupsen/logrus"
 )
 
@@ -34,17 +33,7 @@ func (t *Toast) Fire(entry *logrus.Entry) error {
 		m = "Unknown message."
 	}
 
-	notification := toast.Notification{
-		AppID:   "AutoMIDIcally",
-		Title:   fmt.Sprintf("AutoMIDIcally - %s", l),
-		Message: m.(string),
-	}
-	err = notification.Push()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return push(fmt.Sprintf("AutoMIDIcally - %s", l), m.(string))
 }
 
 func (t *Toast) Levels() []logrus.Level {
@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package toaster
+
+import (
+	"os/exec"
+)
+
+// push shows the notification with notify-send, which hands it to whichever notification daemon the desktop runs.
+func push(title, message string) error {
+	return exec.Command("notify-send", "--app-name=AutoMIDIcally", title, message).Run()
+}
@@ -0,0 +1,15 @@
+package toaster
+
+import (
+	"github.com/go-toast/toast"
+)
+
+// push shows the notification in the Windows 10 notification center.
+func push(title, message string) error {
+	notification := toast.Notification{
+		AppID:   "AutoMIDIcally",
+		Title:   title,
+		Message: message,
+	}
+	return notification.Push()
+}